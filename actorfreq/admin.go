@@ -0,0 +1,142 @@
+package actorfreq
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminCredentials are the configured token, username and password, or those in the auth file
+type adminCredentials struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (ac adminCredentials) basicAuthEnabled() bool {
+	return ac.Username != "" && ac.Password != ""
+}
+
+func (ac adminCredentials) enabled() bool {
+	return ac.Token != "" || ac.basicAuthEnabled()
+}
+
+func (cfg AdminConfig) credentials() (adminCredentials, error) {
+	ac := adminCredentials{Token: cfg.Token, Username: cfg.Username, Password: cfg.Password}
+	if cfg.AuthFile != "" {
+		data, err := os.ReadFile(cfg.AuthFile)
+		if err != nil {
+			return ac, fmt.Errorf("reading admin auth file: %w", err)
+		}
+		if err := json.Unmarshal(data, &ac); err != nil {
+			return ac, fmt.Errorf("parsing admin auth file: %w", err)
+		}
+	}
+	return ac, nil
+}
+
+// authenticate returns who made the request, or false if they didn't present valid credentials
+func (ac adminCredentials) authenticate(r *http.Request) (string, bool) {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && ac.Token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ac.Token)) == 1 {
+			return "token", true
+		}
+		return "", false
+	}
+	if username, password, found := r.BasicAuth(); found && ac.basicAuthEnabled() {
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(ac.Username))
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(ac.Password))
+		if usernameMatches&passwordMatches == 1 {
+			return username, true
+		}
+	}
+	return "", false
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// requireAdmin only lets authenticated admins through to next, and writes an audit log entry for
+// every admin request. Admin endpoints are disabled until credentials are configured.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Method + " " + r.URL.Path
+
+		ac, err := s.config.Admin.credentials()
+		if err != nil {
+			s.logger.Error("Failed to load admin credentials", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "admin_misconfigured", "Admin credentials could not be loaded")
+			return
+		}
+		if !ac.enabled() {
+			s.logger.Warn("Admin audit", "action", action, "remoteAddr", r.RemoteAddr, "outcome", "disabled")
+			writeError(w, r, http.StatusForbidden, "admin_disabled",
+				"Admin endpoints are disabled until ADMIN_TOKEN or ADMIN_USERNAME and ADMIN_PASSWORD are set")
+			return
+		}
+
+		admin, ok := ac.authenticate(r)
+		if !ok {
+			s.logger.Warn("Admin audit", "action", action, "remoteAddr", r.RemoteAddr, "outcome", "unauthorized")
+			if ac.Token != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="actorfreq admin"`)
+			}
+			if ac.basicAuthEnabled() {
+				w.Header().Add("WWW-Authenticate", `Basic realm="actorfreq admin"`)
+			}
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", "Admin credentials are required")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		s.logger.Info("Admin audit", "action", action, "admin", admin, "remoteAddr", r.RemoteAddr, "status", rec.status)
+	})
+}
+
+// addAdminHandlers registers the admin endpoints on their own mux behind requireAdmin, so every
+// endpoint under admin/ needs credentials. Endpoints that change anything are POST only.
+func (s *Server) addAdminHandlers() {
+	root := s.basePath
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc(fmt.Sprintf("GET %sadmin/cache-stats", root), s.cacheStatsHandler)
+	adminMux.HandleFunc(fmt.Sprintf("GET %sadmin/empty-cast-films", root), s.emptyCastFilmsHandler)
+	adminMux.HandleFunc(fmt.Sprintf("GET %sadmin/request-cache", root), s.requestCacheStatsHandler)
+	adminMux.HandleFunc(fmt.Sprintf("GET %sadmin/precache", root), s.precacheStatusHandler)
+	adminMux.HandleFunc(fmt.Sprintf("POST %sadmin/clear-request-cache", root), s.clearRequestCacheHandler)
+	adminMux.HandleFunc(fmt.Sprintf("POST %sclear-request-cache", root), s.clearRequestCacheHandler) // kept for existing scripts
+
+	adminHandler := s.requireAdmin(adminMux)
+	s.mux.Handle(root+"admin/", instrumentHandler(root+"admin/", adminHandler))
+	s.mux.Handle(root+"clear-request-cache", instrumentHandler(root+"clear-request-cache", adminHandler))
+}
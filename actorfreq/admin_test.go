@@ -0,0 +1,91 @@
+package actorfreq
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	var logs bytes.Buffer
+	requestCache := NewCache(1024 * 1024)
+	options := []Option{WithRequestCache(requestCache), WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))}
+	server := NewServer(options...)
+	serve := func(method string, target string, setAuth func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if setAuth != nil {
+			setAuth(req)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+	bearer := func(token string) func(req *http.Request) {
+		return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(username, password string) func(req *http.Request) {
+		return func(req *http.Request) { req.SetBasicAuth(username, password) }
+	}
+
+	if rec := serve(http.MethodPost, "/admin/clear-request-cache", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected admin endpoints to be disabled without credentials, got %d", rec.Code)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "token")
+	authFile := t.TempDir() + "/admin.json"
+	os.WriteFile(authFile, []byte(`{"username": "admin", "password": "hunter2"}`), 0600)
+	t.Setenv("ADMIN_AUTH_FILE", authFile)
+	// Credentials are configured when the server is made
+	server = NewServer(options...)
+
+	cases := []struct {
+		method         string
+		target         string
+		setAuth        func(req *http.Request)
+		expectedStatus int
+	}{
+		{http.MethodPost, "/admin/clear-request-cache", nil, http.StatusUnauthorized},
+		{http.MethodPost, "/admin/clear-request-cache", bearer("wrong"), http.StatusUnauthorized},
+		{http.MethodPost, "/admin/clear-request-cache", basic("admin", "wrong"), http.StatusUnauthorized},
+		{http.MethodPost, "/admin/not-yet-written", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/admin/clear-request-cache", bearer("token"), http.StatusMethodNotAllowed},
+		{http.MethodGet, "/clear-request-cache", bearer("token"), http.StatusMethodNotAllowed},
+		{http.MethodPost, "/clear-request-cache", nil, http.StatusUnauthorized},
+		{http.MethodPost, "/admin/clear-request-cache", bearer("token"), http.StatusOK},
+		{http.MethodPost, "/clear-request-cache", basic("admin", "hunter2"), http.StatusOK},
+		{http.MethodGet, "/admin/request-cache", basic("admin", "hunter2"), http.StatusOK},
+		{http.MethodGet, "/admin/precache", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/admin/precache", bearer("token"), http.StatusOK},
+	}
+	for _, c := range cases {
+		requestCache.set("username=someone", []actorDetails{{Name: "Tom Hanks"}}, time.Minute)
+		rec := serve(c.method, c.target, c.setAuth)
+		if rec.Code != c.expectedStatus {
+			t.Errorf("%s %s: expected status %d, got %d", c.method, c.target, c.expectedStatus, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && len(rec.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s %s: expected bearer and basic challenges, got %v", c.method, c.target, rec.Header().Values("WWW-Authenticate"))
+		}
+		cleared := len(requestCache.items) == 0
+		if cleared != (c.expectedStatus == http.StatusOK && c.method == http.MethodPost) {
+			t.Errorf("%s %s: unexpected request cache state, cleared %v", c.method, c.target, cleared)
+		}
+	}
+
+	expectedLogs := []string{
+		`msg="Admin audit" action="POST /admin/clear-request-cache" remoteAddr=192.0.2.1:1234 outcome=disabled`,
+		`msg="Admin audit" action="POST /admin/clear-request-cache" remoteAddr=192.0.2.1:1234 outcome=unauthorized`,
+		`msg="Admin audit" action="POST /admin/clear-request-cache" admin=token remoteAddr=192.0.2.1:1234 status=200`,
+		`msg="Admin audit" action="POST /clear-request-cache" admin=admin remoteAddr=192.0.2.1:1234 status=200`,
+	}
+	for _, expected := range expectedLogs {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("Expected audit log %s, got:\n%s", expected, logs.String())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package actorfreq
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// requestFilmSlug binds a request's film slugs, in order, to a temporary table for joining
+type requestFilmSlug struct {
+	Slug     string
+	Position int
+}
+
+func (requestFilmSlug) TableName() string { return "request_film_slugs" }
+
+// roleFilterSQL pushes filterRoles into SQL. Suffixes are compared with SUBSTR rather than LIKE,
+// which is case-insensitive in SQLite but not in Postgres or filterRoles.
+func roleFilterSQL(roleFilters []string) (string, []any) {
+	conditions := []string{"credits.roles <> ''"}
+	args := []any{}
+	notSuffix := func(suffix string) {
+		conditions = append(conditions, "SUBSTR(credits.roles, LENGTH(credits.roles) - ?) <> ?")
+		args = append(args, len(suffix)-1, suffix)
+	}
+	for _, roleFilter := range roleFilters {
+		switch roleFilter {
+		case "additional_voices":
+			conditions = append(conditions, "credits.roles NOT IN (?, ?)")
+			args = append(args, "Additional Voices", "Additional Voices (voice)")
+		case "voice":
+			notSuffix("(voice)")
+		case "uncredited":
+			notSuffix("(uncredited)")
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// aggregateCachedActors does the work of getFilms, addFilmCredits and cleanActors in the database
+// when every film is cached, grouping credits by actor so that only the credits of actors
+// appearing more than once are loaded. fetchActors tries it first when the SQLAggregation feature
+// is on, and falls back to the Go path if it returns false because any film isn't cached.
+func (a *analyzer) aggregateCachedActors(filmSlugs []string, roleFilters []string) ([]actorDetails, bool) {
+	if a.db == nil || len(filmSlugs) == 0 {
+		return nil, false
+	}
+
+	rows := []requestFilmSlug{}
+	seen := make(map[string]bool)
+	for i, filmSlug := range filmSlugs {
+		if !seen[filmSlug] {
+			rows = append(rows, requestFilmSlug{Slug: filmSlug, Position: i})
+			seen[filmSlug] = true
+		}
+	}
+
+	var appearances []struct {
+		Actor string
+		Slug  string
+		Title string
+		Roles string
+	}
+	fullyCached := false
+	// Temporary tables are private to the connection, which is ours until this returns. There's
+	// no transaction, since with _txlock=immediate SQLite would take the write lock for it.
+	err := a.db.Connection(func(tx *gorm.DB) error {
+		err := tx.Exec("CREATE TEMPORARY TABLE request_film_slugs (slug TEXT PRIMARY KEY, position INTEGER NOT NULL)").Error
+		if err != nil {
+			return err
+		}
+		defer tx.Exec("DROP TABLE request_film_slugs")
+		if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+			return err
+		}
+
+		var numCached int64
+		err = tx.Table("request_film_slugs").
+			Joins("JOIN films ON films.slug = request_film_slugs.slug AND films.deleted_at IS NULL").
+			Count(&numCached).Error
+		if err != nil {
+			return err
+		}
+
+		fullyCached = int(numCached) == len(rows)
+		if fullyCached {
+			filterSQL, filterArgs := roleFilterSQL(roleFilters)
+			err = tx.Raw(`
+				WITH appearances AS (
+					SELECT credits.actor, films.slug, films.title, credits.roles, request_film_slugs.position
+					FROM request_film_slugs
+					JOIN films ON films.slug = request_film_slugs.slug AND films.deleted_at IS NULL
+					JOIN credits ON credits.film_id = films.id AND credits.deleted_at IS NULL
+					WHERE `+filterSQL+`
+				), frequent_actors AS (
+					SELECT actor FROM appearances GROUP BY actor HAVING COUNT(*) > 1
+				)
+				SELECT appearances.actor, appearances.slug, appearances.title, appearances.roles
+				FROM appearances
+				JOIN frequent_actors ON frequent_actors.actor = appearances.actor
+				ORDER BY appearances.position`, filterArgs...).
+				Scan(&appearances).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		a.logger.Error("Failed to aggregate cached actors", "error", err)
+		return nil, false
+	}
+	if !fullyCached {
+		return nil, false
+	}
+
+	// Appearances come back in film order, so sorting is all that's left for cleanActors
+	actors := make(map[string]*actorDetails)
+	for _, appearance := range appearances {
+		actor, found := actors[appearance.Actor]
+		if !found {
+			actor = &actorDetails{Name: appearance.Actor}
+			actors[appearance.Actor] = actor
+		}
+		actor.Movies = append(actor.Movies, movieDetails{
+			FilmSlug: appearance.Slug,
+			Title:    appearance.Title,
+			Roles:    appearance.Roles,
+		})
+	}
+
+	a.logger.Info("Aggregated cached actors in SQL", "numFilms", len(rows), "numAppearances", len(appearances))
+	return cleanActors(actors), true
+}
@@ -0,0 +1,144 @@
+package actorfreq
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// aggregateActorsInGo is the path fetchActors takes when SQL aggregation is off
+func aggregateActorsInGo(t *testing.T, filmSlugs []string, roleFilters []string) []actorDetails {
+	t.Helper()
+	films, err := defaultAnalyzer().getFilms(context.Background(), filmSlugs, nil)
+	if err != nil {
+		t.Fatalf("Failed to get films: %v", err)
+	}
+	actors := make(map[string]*actorDetails)
+	for _, film := range films {
+		addFilmCredits(actors, film, roleFilters)
+	}
+	return cleanActors(actors)
+}
+
+func TestAggregateCachedActorsMatchesGo(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	films := []Film{
+		{Slug: "toy-story", Title: "Toy Story", Cast: []Credit{
+			{Actor: "Tom Hanks", Roles: "Woody (voice)"},
+			{Actor: "Tim Allen", Roles: "Buzz Lightyear (voice)"},
+			{Actor: "Jack Angel", Roles: "Additional Voices (voice)"},
+		}},
+		{Slug: "big", Title: "Big", Cast: []Credit{
+			{Actor: "Tom Hanks", Roles: "Josh"},
+			{Actor: "Jack Angel", Roles: "Additional Voices"},
+			{Actor: "Zoë Bell", Roles: ""},
+		}},
+		{Slug: "galaxy-quest", Title: "Galaxy Quest", Cast: []Credit{
+			{Actor: "Tim Allen", Roles: "Jason Nesmith"},
+			{Actor: "Zoë Bell", Roles: "Stunts (uncredited)"},
+			{Actor: "Jack Angel", Roles: "Computer (Voice)"},
+		}},
+		{Slug: "sully", Title: "Sully", Cast: []Credit{
+			{Actor: "Tom Hanks", Roles: "Sully / Narrator"},
+			{Actor: "Zoë Bell", Roles: "Passenger"},
+			{Actor: "Aaron Eckhart", Roles: "Jeff"},
+		}},
+		{Slug: "thank-you-for-smoking", Title: "Thank You for Smoking", Cast: []Credit{
+			{Actor: "Aaron Eckhart", Roles: "Nick Naylor"},
+			{Actor: "Tim Allen", Roles: "Narrator (uncredited)"},
+		}},
+	}
+	for _, film := range films {
+		defaultAnalyzer().saveFilmToCache(film)
+	}
+
+	filmSlugs := []string{"sully", "toy-story", "thank-you-for-smoking", "big", "galaxy-quest"}
+	roleFilterCases := [][]string{
+		{},
+		{"voice"},
+		{"uncredited"},
+		{"additional_voices"},
+		{"additional_voices", "voice", "uncredited"},
+	}
+	for _, roleFilters := range roleFilterCases {
+		sqlActors, ok := defaultAnalyzer().aggregateCachedActors(filmSlugs, roleFilters)
+		if !ok {
+			t.Fatalf("Expected SQL aggregation for %v to succeed", roleFilters)
+		}
+		goActors := aggregateActorsInGo(t, filmSlugs, roleFilters)
+		if !reflect.DeepEqual(goActors, sqlActors) {
+			t.Errorf("Role filters %v: expected %+v, got %+v", roleFilters, goActors, sqlActors)
+		}
+	}
+
+	if _, ok := defaultAnalyzer().aggregateCachedActors(append(filmSlugs, "not-cached"), nil); ok {
+		t.Errorf("Expected SQL aggregation to decline films that aren't cached")
+	}
+}
+
+func TestFetchActorsWithAndWithoutSQLAggregation(t *testing.T) {
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/sqlUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />` +
+				`<ul><li class="paginate-page">2</li></ul>`
+		case "https://letterboxd.com/sqlUser/films/by/date/page/2":
+			// The list changed while it was paged through, so splash is listed again
+			responseString = `<div data-film-slug="splash" /><div data-film-slug="sully" />`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	for _, film := range []Film{
+		{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}, {Actor: "John Heard", Roles: "Paul"}}},
+		{Slug: "splash", Title: "Splash", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Allen"}, {Actor: "John Candy", Roles: "Freddie"}}},
+		{Slug: "sully", Title: "Sully", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Sully"}}},
+	} {
+		defaultAnalyzer().saveFilmToCache(film)
+	}
+
+	rc := requestConfig{sortStrategy: "date", roleFilters: []string{}}
+	results := make(map[bool][]actorDetails)
+	for _, sqlAggregation := range []bool{false, true} {
+		a := defaultAnalyzer()
+		a.sqlAggregation = sqlAggregation
+		var reports []Progress
+		actors, err := a.fetchActors(context.Background(), "sqlUser", rc, ProgressFunc(func(p Progress) {
+			reports = append(reports, p)
+		}))
+		if err != nil {
+			t.Fatalf("sqlAggregation %v: failed to fetch actors: %v", sqlAggregation, err)
+		}
+		results[sqlAggregation] = actors
+
+		last := reports[len(reports)-1]
+		if last.Phase != PhaseAggregating || last.Total != 3 || last.Resolved != 3 || last.CacheHits != 3 {
+			t.Errorf("sqlAggregation %v: expected 3 films resolved from the cache, got %+v", sqlAggregation, last)
+		}
+		// Only the Go path loads each film's cast to report it
+		if film := reports[len(reports)-2].Film; (film != nil) == sqlAggregation {
+			t.Errorf("sqlAggregation %v: unexpected film reported, got %+v", sqlAggregation, film)
+		}
+	}
+
+	if len(results[false]) != 1 || len(results[false][0].Movies) != 3 {
+		t.Errorf("Expected Tom Hanks in 3 films, got %+v", results[false])
+	}
+	if !reflect.DeepEqual(results[false], results[true]) {
+		t.Errorf("Expected the SQL path to match the Go path %+v, got %+v", results[false], results[true])
+	}
+}
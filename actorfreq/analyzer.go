@@ -0,0 +1,35 @@
+package actorfreq
+
+import (
+	"log/slog"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// analyzer fetches users' films from Letterboxd and caches the films in db. Each Server has its
+// own, so servers mounted side by side can use different databases and HTTP clients.
+type analyzer struct {
+	db             *gorm.DB // the film cache, or nil to scrape every film
+	httpClient     *http.Client
+	logger         *slog.Logger
+	config         *Config
+	sqlAggregation bool // aggregate fully cached requests in SQL, see aggregateCachedActors
+}
+
+// defaultAnalyzer uses the database set up by SetUpDB and the config from the environment, for
+// the CLI and tests
+func defaultAnalyzer() *analyzer {
+	return newAnalyzer(configFromEnv())
+}
+
+// newAnalyzer uses the database set up by SetUpDB and cfg
+func newAnalyzer(cfg *Config) *analyzer {
+	return &analyzer{
+		db:             cacheDB,
+		httpClient:     http.DefaultClient,
+		logger:         slog.Default(),
+		config:         cfg,
+		sqlAggregation: cfg.Features.SQLAggregation,
+	}
+}
@@ -0,0 +1,107 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const apiVersion = "v1"
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// acceptsJSON reports whether the client asked for JSON instead of an event stream
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, apiErrorResponse{
+		Error: apiError{Status: status, Code: code, Message: message},
+	})
+}
+
+// writeError reports an error as a structured JSON body to API clients and as plain text otherwise
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	if acceptsJSON(r) {
+		writeAPIError(w, status, code, message)
+	} else {
+		http.Error(w, message, status)
+	}
+}
+
+// apiActorsHandler serves GET /api/v1/users/{username}/actors with the same options as fetch-actors
+func (s *Server) apiActorsHandler(w http.ResponseWriter, r *http.Request) {
+	startRequest()
+	defer finishRequest()
+
+	err := r.ParseForm()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_form", "Failed to parse query parameters")
+		return
+	}
+
+	username := r.PathValue("username")
+	if username == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing_username", "Username is required")
+		return
+	}
+
+	if err := validateRequestForm(r.Form); err != nil {
+		writeFieldError(w, r, err)
+		return
+	}
+
+	// Share request cache entries with fetch-actors, which carries the username in the form
+	r.Form.Set("username", username)
+	if s.serveActorsJSON(w, username, getRequestConfig(r.Form), r.Form.Encode()) {
+		s.queueFollowingForPrecache(username)
+	}
+}
+
+// serveActorsJSON writes the actors for username as JSON, reporting whether it succeeded
+func (s *Server) serveActorsJSON(w http.ResponseWriter, username string, rc requestConfig, requestCacheKey string) bool {
+	actors, ok := s.getActorsOrWriteError(w, username, rc, requestCacheKey)
+	if ok {
+		writeJSON(w, http.StatusOK, newResult(username, actors))
+	}
+	return ok
+}
+
+// getActorsOrWriteError gets the actors for username, writing an upstream error if it can't
+func (s *Server) getActorsOrWriteError(w http.ResponseWriter, username string, rc requestConfig, requestCacheKey string) ([]actorDetails, bool) {
+	actors, err := s.getActors(s.analyses, username, rc, requestCacheKey, nil)
+	if err != nil {
+		s.logger.Error("Failed to fetch actors", "username", username, "error", err)
+		writeAPIError(w, http.StatusBadGateway, "upstream_error",
+			fmt.Sprintf("Failed to fetch data from Letterboxd for %q", username))
+		return nil, false
+	}
+	return actors, true
+}
@@ -0,0 +1,99 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIActorsHandler(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/apiUser/films/by/popular/page/1":
+			responseString = `<div data-film-slug="toy-story" />` +
+				`<div data-film-slug="toy-story-2" />`
+		case "https://letterboxd.com/film/toy-story/":
+			responseString = `<h1 class="filmtitle">Toy Story</h1>` +
+				`<a href="/actor/tom-hanks" title="Woody (voice)">Tom Hanks</a>`
+		case "https://letterboxd.com/film/toy-story-2/":
+			responseString = `<h1 class="filmtitle">Toy Story 2</h1>` +
+				`<a href="/actor/tom-hanks" title="Woody (voice)">Tom Hanks</a>`
+		default:
+			responseString = ""
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	server := NewServer()
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/apiUser/actors?sortStrategy=popular", nil))
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", contentType)
+	}
+	var actual map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Failed to unmarshal response %q: %v", rec.Body.String(), err)
+	}
+	expected := map[string]any{
+		"username": "apiUser",
+		"actors": []any{
+			map[string]any{
+				"name":  "Tom Hanks",
+				"count": float64(2),
+				"movies": []any{
+					map[string]any{"filmSlug": "toy-story", "title": "Toy Story", "roles": "Woody (voice)"},
+					map[string]any{"filmSlug": "toy-story-2", "title": "Toy Story 2", "roles": "Woody (voice)"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected response %v, got %v", expected, actual)
+	}
+
+	// fetch-actors skips SSE when the client asks for JSON, and filters like the API does
+	req := httptest.NewRequest(http.MethodGet, "/fetch-actors/?username=apiUser&sortStrategy=popular&roleFilter=voice", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	var negotiated Result
+	if err := json.Unmarshal(rec.Body.Bytes(), &negotiated); err != nil {
+		t.Fatalf("Failed to unmarshal response %q: %v", rec.Body.String(), err)
+	}
+	if negotiated.Username != "apiUser" || len(negotiated.Actors) != 0 {
+		t.Errorf("Expected no actors for apiUser, got %v", negotiated)
+	}
+
+	// Errors are structured for JSON clients
+	req = httptest.NewRequest(http.MethodGet, "/fetch-actors/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	var apiErr apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("Failed to unmarshal error %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusBadRequest || apiErr.Error.Code != "missing_username" {
+		t.Errorf("Expected missing_username error, got %d %v", rec.Code, apiErr)
+	}
+}
@@ -0,0 +1,153 @@
+package actorfreq
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAdminLimit = 20
+	maxAdminLimit     = 1000
+)
+
+type filmCacheStats struct {
+	Films           int64             `json:"films"`
+	Credits         int64             `json:"credits"`
+	DistinctActors  int64             `json:"distinctActors"`
+	EmptyCastFilms  int64             `json:"emptyCastFilms"`
+	StaleFilms      int64             `json:"staleFilms"`
+	OldestFetchedAt *time.Time        `json:"oldestFetchedAt,omitempty"`
+	NewestFetchedAt *time.Time        `json:"newestFetchedAt,omitempty"`
+	TopActors       []cachedActorStat `json:"topActors"`
+}
+
+type cachedActorStat struct {
+	Name  string `json:"name"`
+	Films int    `json:"films"`
+}
+
+type cachedFilmSummary struct {
+	Slug      string     `json:"slug"`
+	Title     string     `json:"title"`
+	FetchedAt time.Time  `json:"fetchedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// emptyCastCondition matches films without any credits
+const emptyCastCondition = "NOT EXISTS (SELECT 1 FROM credits WHERE credits.film_id = films.id AND credits.deleted_at IS NULL)"
+
+func getFilmCacheStats(db *gorm.DB, topActors int) (filmCacheStats, error) {
+	stats := filmCacheStats{TopActors: []cachedActorStat{}}
+	if db == nil {
+		return stats, nil
+	}
+
+	counts := []struct {
+		count *int64
+		query *gorm.DB
+	}{
+		{&stats.Films, db.Model(&Film{})},
+		{&stats.Credits, db.Model(&Credit{})},
+		{&stats.DistinctActors, db.Model(&Credit{}).Distinct("actor")},
+		{&stats.EmptyCastFilms, db.Model(&Film{}).Where(emptyCastCondition)},
+		{&stats.StaleFilms, db.Model(&Film{}).Where("expires_at IS NULL OR expires_at < ?", time.Now())},
+	}
+	for _, c := range counts {
+		if err := c.query.Count(c.count).Error; err != nil {
+			return stats, err
+		}
+	}
+
+	// MIN and MAX come back from SQLite as strings, so read the films themselves
+	var oldest, newest []Film
+	if err := db.Where("fetched_at IS NOT NULL").Order("fetched_at").Limit(1).Find(&oldest).Error; err != nil {
+		return stats, err
+	}
+	if err := db.Where("fetched_at IS NOT NULL").Order("fetched_at DESC").Limit(1).Find(&newest).Error; err != nil {
+		return stats, err
+	}
+	if len(oldest) > 0 && len(newest) > 0 {
+		stats.OldestFetchedAt = &oldest[0].FetchedAt
+		stats.NewestFetchedAt = &newest[0].FetchedAt
+	}
+
+	err := db.Model(&Credit{}).
+		Select("actor AS name, COUNT(*) AS films").
+		Group("actor").
+		Order("films DESC, actor").
+		Limit(topActors).
+		Scan(&stats.TopActors).Error
+	return stats, err
+}
+
+// listEmptyCastFilms lists the films cached without a cast, most recently fetched first
+func listEmptyCastFilms(db *gorm.DB, limit int) ([]cachedFilmSummary, error) {
+	summaries := []cachedFilmSummary{}
+	if db == nil {
+		return summaries, nil
+	}
+
+	var films []Film
+	err := db.Where(emptyCastCondition).Order("fetched_at DESC").Limit(limit).Find(&films).Error
+	for _, film := range films {
+		summaries = append(summaries, cachedFilmSummary{
+			Slug:      film.Slug,
+			Title:     film.Title,
+			FetchedAt: film.FetchedAt,
+			ExpiresAt: film.ExpiresAt,
+		})
+	}
+	return summaries, err
+}
+
+// adminLimit reads the limit query parameter, writing an error response if it is invalid
+func adminLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultAdminLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxAdminLimit {
+		writeFieldError(w, r, &fieldError{Field: "limit", Message: fmt.Sprintf("must be a number from 1 to %d", maxAdminLimit)})
+		return 0, false
+	}
+	return limit, true
+}
+
+func (s *Server) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := adminLimit(w, r)
+	if !ok {
+		return
+	}
+	stats, err := getFilmCacheStats(s.db, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "database_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) emptyCastFilmsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := adminLimit(w, r)
+	if !ok {
+		return
+	}
+	films, err := listEmptyCastFilms(s.db, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "database_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, films)
+}
+
+func (s *Server) requestCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.requestCache == nil {
+		writeJSON(w, http.StatusOK, requestCacheStats{Items: []requestCacheItemStats{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.requestCache.stats())
+}
@@ -0,0 +1,74 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCacheStatsHandlers(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "stats-token")
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}, {Actor: "John Heard", Roles: "Paul"}}})
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "splash", Title: "Splash", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Allen Bauer"}}})
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "no-cast", Title: "No Cast"})
+	cacheDB.Create(&Film{Slug: "legacy", Title: "Legacy", Cast: []Credit{{Actor: "John Heard", Roles: "Paul"}}})
+
+	requestCache := NewCache(1024 * 1024)
+	requestCache.set("username=someone", []actorDetails{{Name: "Tom Hanks"}}, time.Minute)
+	requestCache.set("username=expired", []actorDetails{}, -time.Minute)
+	requestCache.get("username=someone")
+	requestCache.get("username=expired")
+	requestCache.get("username=missing")
+
+	server := NewServer(WithRequestCache(requestCache))
+	get := func(target string, v any) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer stats-token")
+		server.ServeHTTP(rec, req)
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: failed to unmarshal %q: %v", target, rec.Body.String(), err)
+		}
+		return rec.Code
+	}
+
+	var stats filmCacheStats
+	get("/admin/cache-stats?limit=1", &stats)
+	if stats.Films != 4 || stats.Credits != 4 || stats.DistinctActors != 2 || stats.EmptyCastFilms != 1 || stats.StaleFilms != 1 {
+		t.Errorf("Unexpected film cache counts %+v", stats)
+	}
+	if !reflect.DeepEqual(stats.TopActors, []cachedActorStat{{Name: "John Heard", Films: 2}}) {
+		t.Errorf("Expected the top actor by films and then name, got %+v", stats.TopActors)
+	}
+	if stats.OldestFetchedAt == nil || stats.NewestFetchedAt.Before(*stats.OldestFetchedAt) {
+		t.Errorf("Expected oldest and newest fetch times, got %v and %v", stats.OldestFetchedAt, stats.NewestFetchedAt)
+	}
+
+	var emptyCastFilms []cachedFilmSummary
+	get("/admin/empty-cast-films", &emptyCastFilms)
+	if len(emptyCastFilms) != 1 || emptyCastFilms[0].Slug != "no-cast" {
+		t.Errorf("Expected no-cast to be listed, got %+v", emptyCastFilms)
+	}
+
+	var apiErr apiErrorResponse
+	if status := get("/admin/empty-cast-films?limit=0", &apiErr); status != http.StatusBadRequest || apiErr.Error.Field != "limit" {
+		t.Errorf("Expected invalid limit to be rejected, got %d %+v", status, apiErr)
+	}
+
+	var requestCacheStats requestCacheStats
+	get("/admin/request-cache", &requestCacheStats)
+	if requestCacheStats.NumItems != 2 || requestCacheStats.Hits != 1 || requestCacheStats.Misses != 2 {
+		t.Errorf("Unexpected request cache stats %+v", requestCacheStats)
+	}
+	// The hit made username=someone the most recently used
+	if len(requestCacheStats.Items) != 2 || !requestCacheStats.Items[0].Expired || requestCacheStats.Items[1].Expired {
+		t.Errorf("Expected per-key expiry, got %+v", requestCacheStats.Items)
+	}
+}
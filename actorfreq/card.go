@@ -0,0 +1,185 @@
+package actorfreq
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cardSizes are the share card sizes offered, by name, suited to link previews and to posts
+var cardSizes = map[string]image.Point{
+	"landscape": {1200, 630},  // Open Graph and Twitter/X link previews
+	"square":    {1080, 1080}, // Instagram and Mastodon posts
+	"portrait":  {1080, 1350}, // Instagram's tallest post
+}
+
+// cardSizeValues lists cardSizes in the order they're documented
+var cardSizeValues = []string{"landscape", "square", "portrait"}
+
+const (
+	defaultCardActors = 5
+	maxCardActors     = 10
+)
+
+var (
+	cardBackground = color.RGBA{0x14, 0x18, 0x1c, 0xff}
+	cardText       = color.RGBA{0xdd, 0xe6, 0xed, 0xff}
+	cardMutedText  = color.RGBA{0x99, 0xaa, 0xbb, 0xff}
+	cardBarColors  = []color.RGBA{
+		{0xff, 0x80, 0x00, 0xff},
+		{0x00, 0xe0, 0x54, 0xff},
+		{0x40, 0xbc, 0xf4, 0xff},
+	}
+)
+
+// renderCard draws the result's top actors with a bar for each, longest for the actor in the
+// most films, under a title naming the user
+func renderCard(result Result, size image.Point, numActors int) *image.RGBA {
+	card := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(card, card.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+
+	margin := size.X / 20
+	contentWidth := size.X - 2*margin
+
+	// The title is as large as will fit, up to a twelfth of the card's height
+	title := result.Username + "'s most frequent actors"
+	titleScale := max(1, min(size.Y/12/cellHeight, contentWidth/(len([]rune(title))*cellWidth)))
+	drawText(card, image.Pt(margin, margin), truncateText(title, contentWidth, titleScale), titleScale, cardText)
+
+	footerScale := max(1, titleScale/2)
+	footer := "actorfreq"
+	footerTop := size.Y - margin - glyphHeight*footerScale
+	drawText(card, image.Pt(size.X-margin-textWidth(footer, footerScale), footerTop), footer, footerScale, cardMutedText)
+
+	actors := result.Actors[:min(numActors, len(result.Actors))]
+	listTop := margin + cellHeight*titleScale + margin
+	listHeight := footerTop - margin/2 - listTop
+	if len(actors) == 0 {
+		drawText(card, image.Pt(margin, listTop), "Actorigami!", titleScale, cardText)
+		return card
+	}
+
+	rowHeight := listHeight / max(len(actors), defaultCardActors)
+	textScale := max(1, min(rowHeight*2/3/cellHeight, titleScale))
+	maxCount := 0
+	for _, actor := range actors {
+		maxCount = max(maxCount, actor.Count)
+	}
+	countWidth := textWidth(strconv.Itoa(maxCount), textScale) + cellWidth*textScale
+	nameWidth := contentWidth * 2 / 5
+	barLeft := margin + nameWidth + cellWidth*textScale
+	barMaxWidth := margin + contentWidth - countWidth - barLeft
+
+	for i, actor := range actors {
+		top := listTop + i*rowHeight
+		textTop := top + (rowHeight-glyphHeight*textScale)/2
+		name := truncateText(fmt.Sprintf("%d. %s", i+1, actor.Name), nameWidth, textScale)
+		drawText(card, image.Pt(margin, textTop), name, textScale, cardText)
+
+		barWidth := max(textScale, barMaxWidth*actor.Count/maxCount)
+		bar := image.Rect(barLeft, top+rowHeight/5, barLeft+barWidth, top+rowHeight*4/5)
+		draw.Draw(card, bar, image.NewUniform(cardBarColors[i%len(cardBarColors)]), image.Point{}, draw.Src)
+
+		count := strconv.Itoa(actor.Count)
+		drawText(card, image.Pt(bar.Max.X+cellWidth*textScale/2, textTop), count, textScale, cardMutedText)
+	}
+	return card
+}
+
+// parseCardOptions reads the size and actors parameters, removing them from the form so that
+// they don't change the request cache key
+func parseCardOptions(r *http.Request) (size image.Point, numActors int, err *fieldError) {
+	sizeName := r.Form.Get("size")
+	if sizeName == "" {
+		sizeName = "landscape"
+	}
+	size, found := cardSizes[sizeName]
+	if !found {
+		return size, 0, &fieldError{Field: "size", Message: fmt.Sprintf("%q is not one of %s", sizeName, strings.Join(cardSizeValues, ", "))}
+	}
+
+	numActors = defaultCardActors
+	if value := r.Form.Get("actors"); value != "" {
+		n, convErr := strconv.Atoi(value)
+		if convErr != nil || n < 1 || n > maxCardActors {
+			return size, 0, &fieldError{Field: "actors", Message: fmt.Sprintf("must be a number from 1 to %d", maxCardActors)}
+		}
+		numActors = n
+	}
+
+	r.Form.Del("size")
+	r.Form.Del("actors")
+	return size, numActors, nil
+}
+
+// writeCard encodes the card before writing anything, so a failure can still be reported
+func (s *Server) writeCard(w http.ResponseWriter, r *http.Request, result Result, size image.Point, numActors int) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderCard(result, size, numActors)); err != nil {
+		s.logger.Error("Failed to encode card", "username", result.Username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to render card")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// cardHandler serves GET /api/v1/users/{username}/card.png, taking the same options as the
+// actors endpoint as well as the card's size and how many actors to show
+func (s *Server) cardHandler(w http.ResponseWriter, r *http.Request) {
+	startRequest()
+	defer finishRequest()
+
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_form", "Failed to parse query parameters")
+		return
+	}
+	username := r.PathValue("username")
+
+	size, numActors, fieldErr := parseCardOptions(r)
+	if fieldErr == nil {
+		fieldErr = validateRequestForm(r.Form)
+	}
+	if fieldErr != nil {
+		writeFieldError(w, r, fieldErr)
+		return
+	}
+
+	r.Form.Set("username", username)
+	actors, ok := s.getActorsOrWriteError(w, username, getRequestConfig(r.Form), r.Form.Encode())
+	if !ok {
+		return
+	}
+	s.writeCard(w, r, *newResult(username, actors), size, numActors)
+}
+
+// shareCardHandler serves GET /s/{id}/card.png, the card for a share, which its page uses as
+// its Open Graph image
+func (s *Server) shareCardHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Sharing requires a database")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_form", "Failed to parse query parameters")
+		return
+	}
+	size, numActors, fieldErr := parseCardOptions(r)
+	if fieldErr != nil {
+		writeFieldError(w, r, fieldErr)
+		return
+	}
+
+	_, result, ok := s.loadShare(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	s.writeCard(w, r, result, size, numActors)
+}
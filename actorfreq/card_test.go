@@ -0,0 +1,120 @@
+package actorfreq
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderCard(t *testing.T) {
+	result := Result{Username: "cardUser", Actors: []Actor{
+		{Name: "Tom Hanks", Count: 4},
+		{Name: "Penélope Cruz", Count: 2},
+	}}
+	for name, size := range cardSizes {
+		card := renderCard(result, size, defaultCardActors)
+		if card.Bounds().Size() != size {
+			t.Errorf("%s: expected %v, got %v", name, size, card.Bounds().Size())
+		}
+
+		// The first actor's bar is drawn in the first bar color
+		found := false
+		for y := 0; y < size.Y && !found; y++ {
+			for x := 0; x < size.X && !found; x++ {
+				found = card.RGBAAt(x, y) == cardBarColors[0]
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected a bar", name)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	if text := truncateText("Tom Hanks", textWidth("Tom Hanks", 2), 2); text != "Tom Hanks" {
+		t.Errorf("Expected text that fits to be unchanged, got %q", text)
+	}
+	if text := truncateText("Tom Hanks", textWidth("Tom H...", 1), 1); text != "Tom H..." {
+		t.Errorf("Expected Tom H..., got %q", text)
+	}
+}
+
+func TestCards(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/cardUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1><a href="/actor/tom-hanks" title="Allen">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	server := NewServer()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) image.Point {
+		t.Helper()
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("Expected a PNG, got %d: %s", rec.Code, rec.Body.String())
+		}
+		img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to decode card: %v", err)
+		}
+		return img.Bounds().Size()
+	}
+
+	if size := decode(serve(http.MethodGet, "/api/v1/users/cardUser/card.png")); size != image.Pt(1200, 630) {
+		t.Errorf("Expected a landscape card by default, got %v", size)
+	}
+	if size := decode(serve(http.MethodGet, "/api/v1/users/cardUser/card.png?size=square&actors=10")); size != image.Pt(1080, 1080) {
+		t.Errorf("Expected a square card, got %v", size)
+	}
+
+	for _, target := range []string{
+		"/api/v1/users/cardUser/card.png?size=bogus",
+		"/api/v1/users/cardUser/card.png?actors=0",
+		"/api/v1/users/cardUser/card.png?actors=11",
+		"/api/v1/users/cardUser/card.png?sortStrategy=bogus",
+	} {
+		if rec := serve(http.MethodGet, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+
+	// A share's card is its page's Open Graph image
+	var share shareResponse
+	json.Unmarshal(serve(http.MethodPost, "/shares?username=cardUser").Body.Bytes(), &share)
+	if page := serve(http.MethodGet, "/s/"+share.ID).Body.String(); !strings.Contains(page, `<meta property="og:image" content="`+share.URL+`/card.png">`) {
+		t.Errorf("Expected the share page to have the card as its image, got %s", page)
+	}
+	if size := decode(serve(http.MethodGet, "/s/"+share.ID+"/card.png?size=portrait")); size != image.Pt(1080, 1350) {
+		t.Errorf("Expected a portrait card, got %v", size)
+	}
+	if rec := serve(http.MethodGet, "/s/missing1/card.png"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing share's card to be 404, got %d", rec.Code)
+	}
+}
@@ -1,44 +1,481 @@
 package actorfreq
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func CLI() {
-	// Parse command-line arguments
-	username := flag.String("username", "", "The username to fetch data for")
-	sortStrategy := flag.String("sortStrategy", "", "How to sort movies")
-	topNMovies := flag.Int("topNMovies", -1, "Last N movies to fetch data for")
-	flag.Parse()
+const cliUsage = `Usage: actorfreq [-config file] [config flags] <command> [arguments]
 
-	// Ensure a username was provided
-	if *username == "" {
-		slog.Error("Error: Username must be provided.")
-		return
+Commands:
+  serve [-addr address]                      serve the web app, the default command
+  analyze [flags] <username>                 rank the actors in a user's films
+  compare [flags] <username> <username>      rank the actors in both users' films
+  cache stats [limit]                        print film cache statistics
+  cache export|import <file>                 write or read a cache snapshot, "-" for stdout or stdin
+  cache refresh [limit]                      re-fetch the films whose cache entries expired longest ago
+  migrate status|up|down [steps]|to <version>
+  precache [-crawl] <username>...            cache users' films, or those of the people around them
+  config print                               print the effective config, with secrets redacted
+
+The config is read from the YAML or TOML file given by -config or ACTORFREQ_CONFIG, then from
+the environment, then from config flags such as -listenAddr or -filmCache.ttlOld, each
+overriding the last. Run "actorfreq -h" for the config flags and "actorfreq <command> -h" for a
+command's flags.
+`
+
+// CLI runs the command in args, e.g. os.Args[1:], serving the web app if there is none
+func CLI(args []string) error {
+	return runCLI(os.Stdin, os.Stdout, os.Stderr, args)
+}
+
+func runCLI(stdin io.Reader, stdout io.Writer, stderr io.Writer, args []string) error {
+	fs := flag.NewFlagSet("actorfreq", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, cliUsage+"\nConfig flags:\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", os.Getenv("ACTORFREQ_CONFIG"), "the YAML or TOML config file, overriding ACTORFREQ_CONFIG")
+	flagValues := configFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if args[0] == "help" {
+		fmt.Fprint(stdout, cliUsage)
+		return nil
+	}
+
+	cfg, err := loadConfig(*configPath, flagValues)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "serve":
+		err = serveCommand(stderr, cfg, args[1:])
+	case "analyze":
+		err = analyzeCommand(stdout, stderr, cfg, args[1:])
+	case "compare":
+		err = compareCommand(stdout, stderr, cfg, args[1:])
+	case "cache":
+		err = cacheCommand(stdin, stdout, stderr, cfg, args[1:])
+	case "migrate":
+		err = migrateCommand(stdout, cfg, args[1:])
+	case "precache":
+		err = precacheCommand(stdout, stderr, cfg, args[1:])
+	case "config":
+		err = configCommand(stdout, cfg, args[1:])
+	default:
+		fmt.Fprint(stderr, cliUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// parseArgs parses flags wherever they appear among the positional arguments, which it returns
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// stringList is a flag that may be repeated or given comma-separated values
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, strings.Split(value, ",")...)
+	return nil
+}
+
+func serveCommand(stderr io.Writer, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.ListenAddr, "addr", cfg.ListenAddr, "the address to listen on, overriding listenAddr")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if err := setUpDB(cfg.Database); err != nil {
+		return fmt.Errorf("failed to set up the database: %w", err)
+	}
+	return startServer(cfg)
+}
+
+// analysisFlags are the flags shared by the analyze and compare commands
+type analysisFlags struct {
+	options    Options
+	format     string
+	limit      int
+	sequential bool
+	noCache    bool
+	quiet      bool
+	verbose    bool
+}
+
+func newAnalysisFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *analysisFlags) {
+	af := &analysisFlags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&af.options.SortStrategy, "sortStrategy", "date", "the order to list films in: "+strings.Join(sortStrategyValues, ", "))
+	fs.IntVar(&af.options.TopNMovies, "topNMovies", 0, "only analyze the first N films in that order, 0 for all of them")
+	fs.Var((*stringList)(&af.options.RoleFilters), "roleFilter", "roles to leave out: "+strings.Join(roleFilterValues, ", ")+"; may be repeated")
+	fs.StringVar(&af.format, "format", "table", "the output format: "+strings.Join(outputFormats, ", "))
+	fs.IntVar(&af.limit, "limit", 10, "the number of actors to output, 0 for all of them")
+	fs.BoolVar(&af.sequential, "sequential", false, "look films up one at a time, as with features.sequentialFetching")
+	fs.BoolVar(&af.noCache, "no-cache", false, "scrape every film instead of using the film cache database")
+	fs.BoolVar(&af.quiet, "quiet", false, "don't show a progress bar")
+	fs.BoolVar(&af.verbose, "verbose", false, "log what the analysis is doing to stderr")
+	return fs, af
+}
+
+// client makes a Client logging warnings, or everything if verbose, to stderr so that stdout
+// only has the output
+func (af *analysisFlags) client(stderr io.Writer, cfg *Config) (*Client, error) {
+	if !slices.Contains(outputFormats, af.format) {
+		return nil, fmt.Errorf("invalid format %q, expected one of %s", af.format, strings.Join(outputFormats, ", "))
+	}
+
+	level := slog.LevelWarn
+	if af.verbose {
+		level = slog.LevelInfo
+	}
+	features := cfg.Features
+	features.SequentialFetching = features.SequentialFetching || af.sequential
+	options := []Option{
+		WithConfig(cfg),
+		WithLogger(slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: level}))),
+		WithFeatures(features),
+	}
+
+	if !af.noCache {
+		if err := setUpDB(cfg.Database); err != nil {
+			return nil, fmt.Errorf("failed to set up the database, use -no-cache to go without: %w", err)
+		}
+		options = append(options, WithDB(cacheDB))
+	}
+	return NewClient(options...), nil
+}
+
+// analyze runs an analysis with a progress bar, if stderr is a terminal, that Ctrl-C cancels
+func (af *analysisFlags) analyze(client *Client, stderr io.Writer, username string) (*Result, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	options := af.options
+	if file, ok := stderr.(*os.File); ok && !af.quiet && isTerminal(file) {
+		fmt.Fprintf(stderr, "Analyzing %s\n", username)
+		options.Progress = TerminalProgress(stderr)
+	}
+	return client.Analyze(ctx, username, options)
+}
+
+func analyzeCommand(stdout io.Writer, stderr io.Writer, cfg *Config, args []string) error {
+	fs, af := newAnalysisFlagSet("analyze", stderr)
+	usernames, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(usernames) != 1 {
+		return errors.New("usage: analyze [flags] <username>")
+	}
+
+	client, err := af.client(stderr, cfg)
+	if err != nil {
+		return err
+	}
+	result, err := af.analyze(client, stderr, usernames[0])
+	if err != nil {
+		return err
+	}
+	return writeResult(stdout, af.format, result, af.limit)
+}
+
+func compareCommand(stdout io.Writer, stderr io.Writer, cfg *Config, args []string) error {
+	fs, af := newAnalysisFlagSet("compare", stderr)
+	usernames, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(usernames) != 2 {
+		return errors.New("usage: compare [flags] <username> <username>")
+	}
+
+	client, err := af.client(stderr, cfg)
+	if err != nil {
+		return err
+	}
+	var results [2]*Result
+	for i, username := range usernames {
+		if results[i], err = af.analyze(client, stderr, username); err != nil {
+			return err
+		}
+	}
+	return writeComparison(stdout, af.format, compareResults(results[0], results[1]), af.limit)
+}
+
+// precacheCommand caches the films of the given users, or with -crawl of the people around them,
+// working through the precache queue in the foreground until it's empty
+func precacheCommand(stdout io.Writer, stderr io.Writer, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("precache", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	crawl := fs.Bool("crawl", false, "crawl the people around each user, as the server does for requested users")
+	usernames, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(usernames) == 0 {
+		return errors.New("usage: precache [-crawl] <username>...")
 	}
 
-	rc := requestConfig{
-		sortStrategy: *sortStrategy,
-		topNMovies:   *topNMovies,
+	if err := setUpDB(cfg.Database); err != nil {
+		return err
+	}
+	if cacheDB == nil {
+		return errors.New("no database configured")
 	}
 
-	actors := fetchActors(*username, rc, nil)
+	p := newPrecacher(newAnalyzer(cfg))
+	if *crawl {
+		for _, username := range usernames {
+			p.enqueuePrecacheCrawls(username, 0, []string{username})
+		}
+	} else {
+		p.enqueuePrecacheUsers(usernames)
+	}
 
-	// Output top 10 actors
-	printTopActors(actors)
+	numProcessed := p.drain()
+	fmt.Fprintf(stdout, "Processed %d precache tasks\n", numProcessed)
+	return nil
 }
 
-func printTopActors(actors []actorDetails) {
-	fmt.Println("Top 10 Actor appearance counts:")
-	for i, entry := range actors {
-		if i == 0 && len(entry.Movies) == 1 {
-			fmt.Printf("Actorigami!")
+// migrateCommand runs "migrate status|up|down [steps]|to <version>" against the configured database
+func migrateCommand(out io.Writer, cfg *Config, args []string) error {
+	if err := connectDB(cfg.Database); err != nil {
+		return err
+	}
+	if cacheDB == nil {
+		return errors.New("no database configured")
+	}
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	current, err := currentMigrationVersion(cacheDB)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "status":
+		return printMigrationStatus(out)
+	case "up":
+		err = migrateTo(cacheDB, latestMigrationVersion())
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
 		}
-		if i >= 10 {
-			break
+		err = migrateTo(cacheDB, max(current-steps, 0))
+	case "to":
+		if len(args) < 2 {
+			return errors.New("usage: migrate to <version>")
 		}
-		fmt.Printf("%s: %d\n", entry.Name, len(entry.Movies))
+		target, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = migrateTo(cacheDB, target)
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected status, up, down or to", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printMigrationStatus(out)
+}
+
+func printMigrationStatus(out io.Writer) error {
+	applied, err := appliedMigrations(cacheDB)
+	if err != nil {
+		return err
+	}
+	appliedAt := make(map[int]time.Time)
+	for _, m := range applied {
+		appliedAt[m.Version] = m.AppliedAt
+	}
+
+	for _, m := range migrations {
+		status := "pending"
+		if at, found := appliedAt[m.version]; found {
+			status = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%4d  %-28s %s\n", m.version, m.name, status)
+	}
+	if err := checkSchemaVersion(applied); err != nil {
+		fmt.Fprintln(out, err)
+	}
+	return nil
+}
+
+// cacheCommand runs "cache stats [limit]", "cache export <file>", "cache import <file>", where "-"
+// is stdout or stdin, and "cache refresh [limit]"
+func cacheCommand(stdin io.Reader, stdout io.Writer, stderr io.Writer, cfg *Config, args []string) error {
+	hasLimit := len(args) > 0 && (args[0] == "stats" || args[0] == "refresh")
+	if len(args) == 0 || (!hasLimit && len(args) < 2) {
+		return errors.New("usage: cache stats [limit] | cache export|import <file> | cache refresh [limit]")
+	}
+	if !hasLimit && args[0] != "export" && args[0] != "import" {
+		return fmt.Errorf("unknown cache command %q, expected stats, export, import or refresh", args[0])
+	}
+	if err := setUpDB(cfg.Database); err != nil {
+		return err
+	}
+
+	if hasLimit {
+		limit := defaultAdminLimit
+		if args[0] == "refresh" {
+			limit = cfg.FilmCache.RefreshBatchSize
+		}
+		if len(args) > 1 {
+			var err error
+			if limit, err = strconv.Atoi(args[1]); err != nil || limit < 1 {
+				return fmt.Errorf("invalid limit %q", args[1])
+			}
+		}
+		if args[0] == "refresh" {
+			return refreshCommand(stdout, cfg, limit)
+		}
+		return printCacheStats(stdout, limit)
+	}
+
+	command, path := args[0], args[1]
+
+	if command == "export" {
+		out := stdout
+		if path != "-" {
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		_, err := exportSnapshot(out, stderr)
+		return err
+	}
+
+	in := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	_, err := importSnapshot(in, stderr)
+	return err
+}
+
+// printCacheStats prints the film cache statistics. The request cache lives in the server's
+// memory, so it's only available from the admin endpoints.
+func printCacheStats(out io.Writer, limit int) error {
+	stats, err := getFilmCacheStats(cacheDB, limit)
+	if err != nil {
+		return err
+	}
+	emptyCastFilms, err := listEmptyCastFilms(cacheDB, limit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Films:            %d\n", stats.Films)
+	fmt.Fprintf(out, "Credits:          %d\n", stats.Credits)
+	fmt.Fprintf(out, "Distinct actors:  %d\n", stats.DistinctActors)
+	fmt.Fprintf(out, "Stale films:      %d\n", stats.StaleFilms)
+	fmt.Fprintf(out, "Empty casts:      %d\n", stats.EmptyCastFilms)
+	if stats.OldestFetchedAt != nil {
+		fmt.Fprintf(out, "Oldest fetch:     %s\n", stats.OldestFetchedAt.Format(time.RFC3339))
+		fmt.Fprintf(out, "Newest fetch:     %s\n", stats.NewestFetchedAt.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(out, "\nTop %d cached actors:\n", limit)
+	for _, actor := range stats.TopActors {
+		fmt.Fprintf(out, "%6d  %s\n", actor.Films, actor.Name)
+	}
+
+	if len(emptyCastFilms) > 0 {
+		fmt.Fprintln(out, "\nFilms without a cast:")
+		for _, film := range emptyCastFilms {
+			fmt.Fprintf(out, "  %s (%s) fetched %s\n", film.Slug, film.Title, film.FetchedAt.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// refreshCommand re-fetches up to limit of the films that expired longest ago, which the server
+// otherwise leaves to the precache workers
+func refreshCommand(out io.Writer, cfg *Config, limit int) error {
+	if cacheDB == nil {
+		return errors.New("no database configured")
+	}
+
+	a := newAnalyzer(cfg)
+	slugs := staleFilmSlugs(a.db, limit)
+	numFailed := 0
+	for _, slug := range slugs {
+		if err := a.refreshFilm(context.Background(), slug); err != nil {
+			a.logger.Error("Failed to refresh film", "slug", slug, "error", err)
+			numFailed++
+		}
+	}
+
+	fmt.Fprintf(out, "Refreshed %d of %d stale films\n", len(slugs)-numFailed, len(slugs))
+	if numFailed > 0 {
+		return fmt.Errorf("failed to refresh %d films", numFailed)
 	}
+	return nil
 }
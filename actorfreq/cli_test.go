@@ -0,0 +1,127 @@
+package actorfreq
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeAndCompareCommands(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("DISABLE_POSTGRES_DB", "true")
+
+	httpCalls := make(map[string]int)
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		httpCalls[req.URL.String()]++
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/cliUser/films/by/release/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="toy-story" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/otherUser/films/by/release/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" /><div data-film-slug="toy-story" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/toy-story/":
+			responseString = `<h1 class="filmtitle">Toy Story</h1><a href="/actor/tom-hanks" title="Woody (voice)">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash | 1984</h1><a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	run := func(args ...string) string {
+		var stdout, stderr bytes.Buffer
+		if err := runCLI(nil, &stdout, &stderr, args); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	// Flags may come after the username
+	csv := run("analyze", "cliUser", "-sortStrategy", "release", "-roleFilter", "voice", "-format", "csv")
+	expectedCSV := "actor,count,film_slug,title,role\n" +
+		"Tom Hanks,2,big,Big,Josh\n" +
+		"Tom Hanks,2,splash,Splash | 1984,Allen Bauer\n"
+	if csv != expectedCSV {
+		t.Errorf("Expected CSV %q, got %q", expectedCSV, csv)
+	}
+
+	markdown := run("analyze", "-sortStrategy=release", "-topNMovies=2", "-format=markdown", "cliUser")
+	expectedRow := "| 1 | Tom Hanks | 2 | [Big](https://letterboxd.com/film/big/), [Toy Story](https://letterboxd.com/film/toy-story/) |\n"
+	if !strings.HasSuffix(markdown, expectedRow) {
+		t.Errorf("Expected Markdown ending with %q, got %q", expectedRow, markdown)
+	}
+
+	table := run("analyze", "-sortStrategy", "release", "cliUser")
+	if !strings.Contains(table, "1  Tom Hanks  3      Big, Toy Story, Splash | 1984") {
+		t.Errorf("Expected a table row for Tom Hanks, got %q", table)
+	}
+
+	var c comparison
+	if err := json.Unmarshal([]byte(run("compare", "-sortStrategy", "release", "-format", "json", "cliUser", "otherUser")), &c); err != nil {
+		t.Fatalf("Failed to unmarshal comparison: %v", err)
+	}
+	if c.Usernames != [2]string{"cliUser", "otherUser"} || len(c.Actors) != 1 || c.Actors[0].Counts != [2]int{3, 3} {
+		t.Errorf("Expected Tom Hanks in 3 films each, got %+v", c)
+	}
+
+	// Each command sets up a fresh in-memory database, so films are fetched once per command
+	if httpCalls["https://letterboxd.com/film/big/"] != 4 {
+		t.Errorf("Expected films to be cached within a command, got %v", httpCalls)
+	}
+
+	var stderr bytes.Buffer
+	for _, args := range [][]string{
+		{"analyze"},
+		{"analyze", "-format", "yaml", "cliUser"},
+		{"analyze", "-sortStrategy", "bogus", "cliUser"},
+		{"compare", "cliUser"},
+		{"bogus"},
+	} {
+		if err := runCLI(nil, io.Discard, &stderr, args); err == nil {
+			t.Errorf("Expected %v to fail", args)
+		}
+	}
+}
+
+func TestPrecacheCommand(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("DISABLE_POSTGRES_DB", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/precacheUser/films/by/release/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	var stdout bytes.Buffer
+	if err := runCLI(nil, &stdout, io.Discard, []string{"precache", "precacheUser"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stdout.String() != "Processed 3 precache tasks\n" {
+		t.Errorf("Expected the user and their 2 films to be processed, got %q", stdout.String())
+	}
+	if film, found := defaultAnalyzer().fetchCachedFilm("big"); !found || film.Title != "Big" {
+		t.Errorf("Expected big to be cached, got %+v", film)
+	}
+}
@@ -0,0 +1,130 @@
+package actorfreq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Client analyzes Letterboxd users' films in-process, for programs that want results rather than
+// an HTTP server
+type Client struct {
+	settings
+}
+
+// NewClient makes a Client. Unlike a Server it reads nothing from the environment: it scrapes
+// every film unless given a film cache with WithDB, and doesn't cache results unless given
+// WithRequestCache. Of the Features, only SQLAggregation and SequentialFetching apply.
+func NewClient(options ...Option) *Client {
+	cfg := configOption(options)
+	var features Features
+	if cfg == nil {
+		cfg = DefaultConfig()
+	} else {
+		features = cfg.Features
+	}
+	c := &Client{settings: settings{
+		analyzer: &analyzer{httpClient: http.DefaultClient, logger: slog.Default(), config: cfg},
+		basePath: "/",
+		features: features,
+	}}
+	for _, option := range options {
+		option(&c.settings)
+	}
+	c.sqlAggregation = c.features.SQLAggregation
+	return c
+}
+
+// Options are how Analyze reads a user's films. The zero value analyzes all of them, counting
+// every role.
+type Options struct {
+	SortStrategy string           // the order to list films in, as on Letterboxd, "date" by default
+	TopNMovies   int              // only analyze the first films in that order, or 0 for all of them
+	RoleFilters  []string         // roles to leave out: "additional_voices", "voice" or "uncredited"
+	Progress     ProgressReporter // told how the analysis is getting on, or nil
+}
+
+// form encodes the options as the query parameters the HTTP API takes, so both are validated
+// the same way and share request cache entries
+func (o Options) form(username string) url.Values {
+	form := url.Values{"username": {username}}
+	if o.SortStrategy != "" {
+		form.Set("sortStrategy", o.SortStrategy)
+	}
+	if o.TopNMovies != 0 {
+		form.Set("topNMovies", strconv.Itoa(o.TopNMovies))
+	}
+	for _, roleFilter := range o.RoleFilters {
+		form.Add("roleFilter", roleFilter)
+	}
+	return form
+}
+
+// Result is the actors appearing in more than one of a user's films, most frequent first
+type Result struct {
+	Username string  `json:"username"`
+	Actors   []Actor `json:"actors"`
+}
+
+// Actor is an actor and the user's films they appear in
+type Actor struct {
+	Name   string  `json:"name"`
+	Count  int     `json:"count"`
+	Movies []Movie `json:"movies"`
+}
+
+// Movie is one of an actor's films, with the roles that survived the role filters
+type Movie struct {
+	FilmSlug string `json:"filmSlug"`
+	Title    string `json:"title"`
+	Roles    string `json:"roles"`
+}
+
+func newResult(username string, actors []actorDetails) *Result {
+	result := &Result{Username: username, Actors: []Actor{}}
+	for _, actor := range actors {
+		movies := []Movie{}
+		for _, movie := range actor.Movies {
+			movies = append(movies, Movie{FilmSlug: movie.FilmSlug, Title: movie.Title, Roles: movie.Roles})
+		}
+		result.Actors = append(result.Actors, Actor{Name: actor.Name, Count: len(movies), Movies: movies})
+	}
+	return result
+}
+
+// ErrInvalidOptions is wrapped by the errors Analyze returns for a missing username or invalid
+// Options
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Analyze finds the actors appearing most often in username's films. It returns ctx's error if
+// ctx is done first, and an error if Letterboxd can't be scraped.
+func (c *Client) Analyze(ctx context.Context, username string, options Options) (*Result, error) {
+	if username == "" {
+		return nil, fmt.Errorf("%w: username is required", ErrInvalidOptions)
+	}
+	if options.TopNMovies < 0 {
+		return nil, fmt.Errorf("%w: topNMovies must not be negative", ErrInvalidOptions)
+	}
+	form := options.form(username)
+	if fieldErr := validateRequestForm(form); fieldErr != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrInvalidOptions, fieldErr.Field, fieldErr.Message)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	actors, err := c.getActors(ctx, username, getRequestConfig(form), form.Encode(), options.Progress)
+	if err != nil {
+		c.logger.Error("Failed to fetch actors", "username", username, "error", err)
+		// Scraping usually fails because ctx is done
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to fetch data from Letterboxd for %q: %w", username, err)
+	}
+	return newResult(username, actors), nil
+}
@@ -0,0 +1,106 @@
+package actorfreq
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientAnalyze(t *testing.T) {
+	httpCalls := make(map[string]int)
+	client := NewClient(WithHTTPClient(&http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		httpCalls[req.URL.String()]++
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/clientUser/films/by/release/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="toy-story" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/toy-story/":
+			responseString = `<h1 class="filmtitle">Toy Story</h1><a href="/actor/tom-hanks" title="Woody (voice)">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1><a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})}))
+
+	var reports []Progress
+	result, err := client.Analyze(context.Background(), "clientUser", Options{
+		SortStrategy: "release",
+		RoleFilters:  []string{"voice"},
+		Progress:     ProgressFunc(func(p Progress) { reports = append(reports, p) }),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expectedResult := &Result{Username: "clientUser", Actors: []Actor{{
+		Name:  "Tom Hanks",
+		Count: 2,
+		Movies: []Movie{
+			{FilmSlug: "big", Title: "Big", Roles: "Josh"},
+			{FilmSlug: "splash", Title: "Splash", Roles: "Allen Bauer"},
+		},
+	}}}
+	if !reflect.DeepEqual(expectedResult, result) {
+		t.Errorf("Expected %+v, got %+v", expectedResult, result)
+	}
+
+	var phases []Phase
+	for _, report := range reports {
+		phases = append(phases, report.Phase)
+	}
+	expectedPhases := []Phase{PhaseListing, PhaseListing, PhaseResolving, PhaseResolving, PhaseResolving, PhaseResolving, PhaseAggregating}
+	if !reflect.DeepEqual(expectedPhases, phases) {
+		t.Errorf("Expected phases %v, got %v", expectedPhases, phases)
+	}
+	if film := reports[3].Film; film == nil || film.Slug != "big" || film.Cached || reports[3].Resolved != 1 || reports[3].Total != 3 {
+		t.Errorf("Expected the first film to be reported as fetched, got %+v", reports[3])
+	}
+
+	// Without a film cache every analysis scrapes every film
+	client.Analyze(context.Background(), "clientUser", Options{SortStrategy: "release"})
+	if httpCalls["https://letterboxd.com/film/big/"] != 2 {
+		t.Errorf("Expected films to be fetched again without a cache, got %v", httpCalls)
+	}
+}
+
+func TestClientAnalyzeErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewClient(WithHTTPClient(&http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		// Give up while the remaining pages are being listed in parallel
+		cancel()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`<div data-film-slug="big" /><li class="paginate-page">3</li>`)),
+			Header:     make(http.Header),
+		}, nil
+	})}))
+
+	cases := []struct {
+		username      string
+		options       Options
+		expectedError error
+	}{
+		{"", Options{}, ErrInvalidOptions},
+		{"someone", Options{SortStrategy: "bogus"}, ErrInvalidOptions},
+		{"someone", Options{TopNMovies: -1}, ErrInvalidOptions},
+		{"someone", Options{RoleFilters: []string{"cameo"}}, ErrInvalidOptions},
+		{"someone", Options{}, context.Canceled},
+	}
+	for _, c := range cases {
+		result, err := client.Analyze(ctx, c.username, c.options)
+		if !errors.Is(err, c.expectedError) || result != nil {
+			t.Errorf("Expected %v for %q %+v, got %v %+v", c.expectedError, c.username, c.options, err, result)
+		}
+	}
+}
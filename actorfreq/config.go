@@ -0,0 +1,415 @@
+package actorfreq
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything that can be configured. LoadConfig reads it from, in increasing order of
+// precedence: the defaults, a YAML or TOML file, environment variables and command-line flags.
+// Each setting's file key, which is also its flag name, is its yaml tag, nested with dots, e.g.
+// "filmCache.ttlOld" and -filmCache.ttlOld; its environment variable is its env tag.
+type Config struct {
+	ListenAddr      string             `yaml:"listenAddr" env:"LISTEN_ADDR"`
+	PublicURL       string             `yaml:"publicURL" env:"PUBLIC_URL"`         // e.g. https://example.com, for absolute links such as share URLs
+	ShutdownDelay   time.Duration      `yaml:"shutdownDelay" env:"SHUTDOWN_DELAY"` // how long /readyz reports shutting down before connections are drained
+	ShutdownTimeout time.Duration      `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	Database        DatabaseConfig     `yaml:"database"`
+	Admin           AdminConfig        `yaml:"admin"`
+	RequestCache    RequestCacheConfig `yaml:"requestCache"`
+	FilmCache       FilmCacheConfig    `yaml:"filmCache"`
+	Precache        PrecacheConfig     `yaml:"precache"`
+	Share           ShareConfig        `yaml:"share"`
+	Features        Features           `yaml:"features"`
+}
+
+// DatabaseConfig chooses the film cache database: URL if it's set, otherwise the Postgres
+// database described by the other settings, otherwise an in-memory SQLite database
+type DatabaseConfig struct {
+	URL                   string `yaml:"url" env:"DATABASE_URL" secret:"url"` // see openDatabaseURL
+	Host                  string `yaml:"host" env:"ACTORFREQ_DB_HOST"`
+	Port                  string `yaml:"port" env:"ACTORFREQ_DB_PORT"`
+	User                  string `yaml:"user" env:"ACTORFREQ_DB_USER"`
+	Password              string `yaml:"password" env:"ACTORFREQ_DB_PASSWORD" secret:"true"`
+	Name                  string `yaml:"name" env:"ACTORFREQ_DB_NAME"`
+	SSLMode               string `yaml:"sslMode" env:"ACTORFREQ_DB_SSLMODE"` // e.g. disable or verify-full, the driver's default if empty
+	DisablePostgres       bool   `yaml:"disablePostgres" env:"DISABLE_POSTGRES_DB"`
+	DisableInMemorySQLite bool   `yaml:"disableInMemorySQLite" env:"DISABLE_IN_MEMORY_SQLITE_DB"`
+}
+
+// AdminConfig holds the admin credentials, which may instead come from a JSON file such as
+// {"token": "...", "username": "...", "password": "..."}
+type AdminConfig struct {
+	Token    string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+	Username string `yaml:"username" env:"ADMIN_USERNAME"`
+	Password string `yaml:"password" env:"ADMIN_PASSWORD" secret:"true"`
+	AuthFile string `yaml:"authFile" env:"ADMIN_AUTH_FILE"`
+}
+
+// RequestCacheConfig sizes each server's cache of analysis results
+type RequestCacheConfig struct {
+	MaxSize int           `yaml:"maxSize" env:"REQUEST_CACHE_MAX_SIZE"` // in bytes
+	TTL     time.Duration `yaml:"ttl" env:"REQUEST_CACHE_TTL"`
+}
+
+// FilmCacheConfig is how long cached films are trusted and how they're refreshed
+type FilmCacheConfig struct {
+	TTLRecent        time.Duration `yaml:"ttlRecent" env:"FILM_CACHE_TTL_RECENT"` // for films released within RecentYears
+	TTLOld           time.Duration `yaml:"ttlOld" env:"FILM_CACHE_TTL_OLD"`       // for older films, whose casts rarely change
+	TTLEmpty         time.Duration `yaml:"ttlEmpty" env:"FILM_CACHE_TTL_EMPTY"`   // for films cached without a cast
+	RecentYears      int           `yaml:"recentYears" env:"FILM_CACHE_RECENT_YEARS"`
+	RefreshInterval  time.Duration `yaml:"refreshInterval" env:"FILM_REFRESH_INTERVAL"`
+	RefreshBatchSize int           `yaml:"refreshBatchSize" env:"FILM_REFRESH_BATCH_SIZE"`     // films queued for refresh per interval
+	LookupBatchSize  int           `yaml:"lookupBatchSize" env:"FILM_CACHE_LOOKUP_BATCH_SIZE"` // slugs per cache query
+}
+
+// PrecacheConfig sizes the background precaching
+type PrecacheConfig struct {
+	Workers        int  `yaml:"workers" env:"PRECACHE_WORKERS"`
+	CrawlDepth     int  `yaml:"crawlDepth" env:"PRECACHE_CRAWL_DEPTH"`   // how many hops away from the seed to crawl
+	CrawlBudget    int  `yaml:"crawlBudget" env:"PRECACHE_CRAWL_BUDGET"` // how many users to reach per seed
+	CrawlFollowers bool `yaml:"crawlFollowers" env:"PRECACHE_CRAWL_FOLLOWERS"`
+}
+
+// ShareConfig is how long shared results are kept
+type ShareConfig struct {
+	TTL    time.Duration `yaml:"ttl" env:"SHARE_TTL"`        // unless the creator asks for less
+	MaxTTL time.Duration `yaml:"maxTTL" env:"SHARE_MAX_TTL"` // the longest a creator may ask for
+}
+
+// DefaultConfig is the config before any file, environment variables or flags are applied
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:      defaultListenAddr,
+		ShutdownDelay:   defaultShutdownDelay,
+		ShutdownTimeout: defaultShutdownTimeout,
+		RequestCache: RequestCacheConfig{
+			MaxSize: 100 * 1024 * 1024,
+			TTL:     10 * time.Minute,
+		},
+		FilmCache: FilmCacheConfig{
+			TTLRecent:        7 * 24 * time.Hour,
+			TTLOld:           180 * 24 * time.Hour,
+			TTLEmpty:         24 * time.Hour,
+			RecentYears:      2,
+			RefreshInterval:  time.Hour,
+			RefreshBatchSize: 100,
+			LookupBatchSize:  500,
+		},
+		Precache: PrecacheConfig{
+			Workers:     2,
+			CrawlDepth:  1,
+			CrawlBudget: 500,
+		},
+		Share: ShareConfig{
+			TTL:    30 * 24 * time.Hour,
+			MaxTTL: 365 * 24 * time.Hour,
+		},
+		Features: Features{
+			PrecacheFollowing: true,
+			FilmRefresh:       true,
+			Metrics:           true,
+			Admin:             true,
+		},
+	}
+}
+
+// LoadConfig applies the YAML or TOML file at path, if path isn't empty, and then the
+// environment to the defaults, and validates the result
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path, nil)
+}
+
+// loadConfig is LoadConfig with flags, by setting path, applied last
+func loadConfig(path string, flags map[string]string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		if err := cfg.applyFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	settings := cfg.settings()
+	for path, value := range flags {
+		if err := settings[path].set(value); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %w", path, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configFromEnv is the config for servers and analyses set up without one. Invalid values are
+// logged and left at their defaults, since only LoadConfig can refuse to start.
+func configFromEnv() *Config {
+	cfg := DefaultConfig()
+	if err := cfg.applyEnv(); err != nil {
+		slog.Warn("Ignoring invalid configuration", "error", err)
+	}
+	return cfg
+}
+
+// setting is a leaf of the Config, addressed by its dotted path
+type setting struct {
+	path   string
+	env    string
+	negate bool   // a DISABLE_* variable for a setting that enables something
+	secret string // "true" to redact the value, "url" to redact a URL's password
+	value  reflect.Value
+}
+
+// settings lists the Config's settings by path, pointing into cfg so they can be set
+func (cfg *Config) settings() map[string]setting {
+	settings := make(map[string]setting)
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			path := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(path+".", v.Field(i))
+				continue
+			}
+			env, options, _ := strings.Cut(field.Tag.Get("env"), ",")
+			settings[path] = setting{
+				path:   path,
+				env:    env,
+				negate: options == "negate",
+				secret: field.Tag.Get("secret"),
+				value:  v.Field(i),
+			}
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return settings
+}
+
+func (s setting) set(value string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		s.value.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		s.value.SetInt(int64(i))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 90s or 12h", value)
+		}
+		s.value.SetInt(int64(d))
+	}
+	return nil
+}
+
+// applyFile decodes a .yaml, .yml or .toml file over cfg, refusing keys it doesn't know
+func (cfg *Config) applyFile(path string) error {
+	ext := filepath.Ext(path)
+	if ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	if ext == ".toml" {
+		// TOML goes through YAML so both are decoded by the same tags
+		var values map[string]any
+		if _, err := toml.Decode(string(data), &values); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(values); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) applyEnv() error {
+	var errs []error
+	for _, s := range cfg.settings() {
+		value, found := os.LookupEnv(s.env)
+		if s.env == "" || !found || value == "" {
+			continue
+		}
+		if s.negate {
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %q is not a boolean", s.env, value))
+				continue
+			}
+			value = strconv.FormatBool(!disabled)
+		}
+		if err := s.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", s.env, err))
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// Validate reports every setting that's out of range or inconsistent
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(path string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("invalid %s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		invalid("listenAddr", "%q is not a host:port address", cfg.ListenAddr)
+	}
+	if cfg.PublicURL != "" {
+		if u, err := url.Parse(cfg.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("publicURL", "%q is not an http:// or https:// URL", cfg.PublicURL)
+		}
+	}
+	if cfg.Database.URL != "" {
+		if u, err := url.Parse(cfg.Database.URL); err != nil || u.Scheme == "" {
+			invalid("database.url", "not a postgres:// or sqlite:// URL")
+		}
+	}
+	if (cfg.Admin.Username == "") != (cfg.Admin.Password == "") {
+		invalid("admin", "username and password must be set together")
+	}
+	if _, err := cfg.Admin.credentials(); err != nil {
+		invalid("admin.authFile", "%v", err)
+	}
+
+	if cfg.ShutdownDelay < 0 {
+		invalid("shutdownDelay", "must not be negative")
+	}
+	for path, value := range map[string]time.Duration{
+		"shutdownTimeout":           cfg.ShutdownTimeout,
+		"requestCache.ttl":          cfg.RequestCache.TTL,
+		"filmCache.ttlRecent":       cfg.FilmCache.TTLRecent,
+		"filmCache.ttlOld":          cfg.FilmCache.TTLOld,
+		"filmCache.ttlEmpty":        cfg.FilmCache.TTLEmpty,
+		"filmCache.refreshInterval": cfg.FilmCache.RefreshInterval,
+		"share.ttl":                 cfg.Share.TTL,
+		"share.maxTTL":              cfg.Share.MaxTTL,
+	} {
+		if value <= 0 {
+			invalid(path, "must be positive")
+		}
+	}
+	for path, value := range map[string]int{
+		"requestCache.maxSize":       cfg.RequestCache.MaxSize,
+		"filmCache.refreshBatchSize": cfg.FilmCache.RefreshBatchSize,
+		"filmCache.lookupBatchSize":  cfg.FilmCache.LookupBatchSize,
+		"precache.workers":           cfg.Precache.Workers,
+		"precache.crawlDepth":        cfg.Precache.CrawlDepth,
+		"precache.crawlBudget":       cfg.Precache.CrawlBudget,
+	} {
+		if value < 1 {
+			invalid(path, "must be at least 1")
+		}
+	}
+	// Creators ask for a number of days, so there must be at least one to choose
+	if cfg.Share.MaxTTL < 24*time.Hour {
+		invalid("share.maxTTL", "must be at least a day")
+	}
+	if cfg.Share.TTL > cfg.Share.MaxTTL {
+		invalid("share.ttl", "must not be longer than share.maxTTL")
+	}
+	if cfg.FilmCache.RecentYears < 0 {
+		invalid("filmCache.recentYears", "must not be negative")
+	}
+
+	// Map iteration is random, so sort the errors to report them the same way every time
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// redacted is a copy of the config that's safe to print, with secrets masked
+func (cfg *Config) redacted() *Config {
+	copied := *cfg
+	for _, s := range copied.settings() {
+		value := s.value.String()
+		if value == "" {
+			continue
+		}
+		switch s.secret {
+		case "true":
+			s.value.SetString("REDACTED")
+		case "url":
+			if u, err := url.Parse(value); err == nil {
+				s.value.SetString(u.Redacted())
+			} else {
+				s.value.SetString("REDACTED")
+			}
+		}
+	}
+	return &copied
+}
+
+// configFlags registers a flag for every setting on fs, recording the values given by path so
+// that they can be applied over the file and the environment
+func configFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	defaults := DefaultConfig()
+	for path, s := range defaults.settings() {
+		usage := fmt.Sprintf("overrides %s in the config file", path)
+		if s.env != "" {
+			usage = fmt.Sprintf("overrides %s and %s in the config file", s.env, path)
+		}
+		if s.secret == "" && !s.value.IsZero() {
+			usage += fmt.Sprintf(" (default %v)", s.value.Interface())
+		}
+		fs.Func(path, usage, func(value string) error {
+			if err := DefaultConfig().settings()[path].set(value); err != nil {
+				return err
+			}
+			values[path] = value
+			return nil
+		})
+	}
+	return values
+}
+
+// configCommand runs "config print", printing the effective config as YAML
+func configCommand(out io.Writer, cfg *Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg.redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
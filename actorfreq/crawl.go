@@ -0,0 +1,94 @@
+package actorfreq
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SocialEdge records that a user was reached while crawling out from one of our own users
+type SocialEdge struct {
+	ID        uint   `gorm:"primaryKey"`
+	Seed      string `gorm:"uniqueIndex:idx_social_edge_seed_username"`
+	Username  string `gorm:"uniqueIndex:idx_social_edge_seed_username;index"`
+	CreatedAt time.Time
+}
+
+// crawl expands a crawl task, queueing the users it reaches for film listing and further crawling
+func (p *precacher) crawl(task PrecacheTask) error {
+	cc := p.config.Precache
+	if task.Depth >= cc.CrawlDepth {
+		return nil
+	}
+
+	var numReached int64
+	p.db.Model(&SocialEdge{}).Where("seed = ?", task.Seed).Count(&numReached)
+	remaining := cc.CrawlBudget - int(numReached)
+	if remaining <= 0 {
+		p.logger.Info("Crawl budget exhausted", "seed", task.Seed, "budget", cc.CrawlBudget)
+		return nil
+	}
+
+	p.logger.Info("Crawling user", "username", task.Value, "seed", task.Seed, "depth", task.Depth)
+	people, err := p.fetchFollowing(context.Background(), task.Value, remaining)
+	if err != nil {
+		return err
+	}
+	if cc.CrawlFollowers && len(people) < remaining {
+		followers, err := p.fetchFollowers(context.Background(), task.Value, remaining-len(people))
+		if err != nil {
+			return err
+		}
+		people = append(people, followers...)
+	}
+
+	reached := []string{}
+	for _, person := range people {
+		if person != task.Seed && !slices.Contains(reached, person) {
+			reached = append(reached, person)
+		}
+	}
+	if len(reached) == 0 {
+		return nil
+	}
+
+	edges := []SocialEdge{}
+	for _, person := range reached {
+		edges = append(edges, SocialEdge{Seed: task.Seed, Username: person})
+	}
+	err = p.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&edges, 500).Error
+	if err != nil {
+		return fmt.Errorf("saving social edges: %w", err)
+	}
+
+	p.enqueuePrecacheUsers(reached)
+	if task.Depth+1 < cc.CrawlDepth {
+		p.enqueuePrecacheCrawls(task.Seed, task.Depth+1, reached)
+	}
+	return nil
+}
+
+// seedCounts counts how many of our own users reach each of usernames
+func (p *precacher) seedCounts(usernames []string) map[string]int {
+	counts := make(map[string]int)
+	batchSize := p.config.FilmCache.LookupBatchSize
+	for i := 0; i < len(usernames); i += batchSize {
+		end := min(i+batchSize, len(usernames))
+		var rows []struct {
+			Username  string
+			SeedCount int
+		}
+		p.db.Model(&SocialEdge{}).
+			Select("username, COUNT(DISTINCT seed) AS seed_count").
+			Where("username IN (?)", usernames[i:end]).
+			Group("username").
+			Scan(&rows)
+		for _, row := range rows {
+			counts[row.Username] = row.SeedCount
+		}
+	}
+	return counts
+}
@@ -1,94 +1,249 @@
 package actorfreq
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/url"
 	"os"
-	"reflect"
+	"strconv"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var cacheDB *gorm.DB
 
-func SetUpDB() {
-	if os.Getenv("DISABLE_POSTGRES_DB") != "true" {
-		setUpPostgresDB()
-	} else if os.Getenv("DISABLE_IN_MEMORY_SQLITE_DB") != "true" {
-		setUpInMemorySQLiteDB()
+// SetUpDB connects to the cache database configured by the environment and applies any pending
+// migrations
+func SetUpDB() error {
+	return setUpDB(configFromEnv().Database)
+}
+
+func setUpDB(cfg DatabaseConfig) error {
+	if err := connectDB(cfg); err != nil {
+		return err
 	}
-	setUpGORMTables()
+	return migrateDB()
 }
 
-func setUpPostgresDB() {
-	dbHost := os.Getenv("ACTORFREQ_DB_HOST")
-	dbPort := os.Getenv("ACTORFREQ_DB_PORT")
-	dbUser := os.Getenv("ACTORFREQ_DB_USER")
-	dbPassword := os.Getenv("ACTORFREQ_DB_PASSWORD")
-	dbName := os.Getenv("ACTORFREQ_DB_NAME")
+// connectDB opens cfg.URL if it is set, and otherwise falls back to the other Postgres settings
+// or an in-memory SQLite database
+func connectDB(cfg DatabaseConfig) error {
+	if cfg.URL != "" {
+		return openDatabaseURL(cfg.URL)
+	}
+	if !cfg.DisablePostgres {
+		return setUpPostgresDB(cfg)
+	} else if !cfg.DisableInMemorySQLite {
+		return setUpInMemorySQLiteDB()
+	}
+	return nil
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+// openDatabaseURL connects to a postgres:// or sqlite:///path/to/file.db URL. The
+// max_open_conns, max_idle_conns and conn_max_lifetime query parameters size the connection
+// pool, and any other parameters are passed to the driver, e.g. sslmode, sslrootcert, sslcert
+// and sslkey for Postgres or _busy_timeout and _journal_mode for SQLite.
+func openDatabaseURL(databaseURL string) error {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		// url.Error includes the whole URL, password and all
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
 
-	var err error
-	cacheDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	query := u.Query()
+	pool, err := parsePoolConfig(query)
 	if err != nil {
-		slog.Error("Failed to connect to the database:", "error", err)
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
 	}
+	u.RawQuery = query.Encode()
+
+	var dialector gorm.Dialector
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		dialector = postgres.Open(u.String())
+	case "sqlite":
+		dsn, err := sqliteDSN(u)
+		if err != nil {
+			return fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		dialector = sqlite.Open(dsn)
+	default:
+		return fmt.Errorf("invalid DATABASE_URL: unsupported scheme %q, expected postgres or sqlite", u.Scheme)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", u.Redacted(), err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", u.Redacted(), err)
+	}
+	pool.apply(sqlDB)
+
+	slog.Info("Connected to database", "url", u.Redacted())
+	cacheDB = db
+	return nil
 }
 
-func setUpInMemorySQLiteDB() {
+type poolConfig struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// parsePoolConfig removes the pool sizing parameters from query so they aren't passed to the driver
+func parsePoolConfig(query url.Values) (poolConfig, error) {
+	var pool poolConfig
 	var err error
-	cacheDB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	if err != nil {
-		slog.Error("Failed to connect to in-memory SQLite database")
+	if value := query.Get("max_open_conns"); value != "" {
+		if pool.maxOpenConns, err = strconv.Atoi(value); err != nil {
+			return pool, fmt.Errorf("max_open_conns %q is not a number", value)
+		}
+	}
+	if value := query.Get("max_idle_conns"); value != "" {
+		if pool.maxIdleConns, err = strconv.Atoi(value); err != nil {
+			return pool, fmt.Errorf("max_idle_conns %q is not a number", value)
+		}
 	}
+	if value := query.Get("conn_max_lifetime"); value != "" {
+		if pool.connMaxLifetime, err = time.ParseDuration(value); err != nil {
+			return pool, fmt.Errorf("conn_max_lifetime %q is not a duration", value)
+		}
+	}
+	query.Del("max_open_conns")
+	query.Del("max_idle_conns")
+	query.Del("conn_max_lifetime")
+	return pool, nil
 }
 
-func setUpGORMTables() {
-	if cacheDB != nil {
-		for _, table := range []any{&Film{}, &Credit{}} {
-			if os.Getenv("FORCE_DB_RESET") == "true" {
-				slog.Warn("FORCE_DB_RESET set, dropping table", "table", reflect.TypeOf(table))
-				cacheDB.Migrator().DropTable(table)
-			}
-			cacheDB.AutoMigrate(table)
+func (pool poolConfig) apply(sqlDB *sql.DB) {
+	if pool.maxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.maxOpenConns)
+	}
+	if pool.maxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.maxIdleConns)
+	}
+	if pool.connMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.connMaxLifetime)
+	}
+}
+
+// sqliteDSN turns a sqlite:///path/to/file.db URL into a go-sqlite3 DSN, defaulting to WAL mode
+// so reads don't block on writes, a busy timeout so concurrent writers wait their turn instead
+// of failing, and immediate transactions so they can't deadlock upgrading a read to a write
+func sqliteDSN(u *url.URL) (string, error) {
+	path := u.Host + u.Path // sqlite://cache.db is relative, sqlite:///data/cache.db absolute
+	if path == "" {
+		return "", errors.New("missing SQLite database path")
+	}
+
+	query := u.Query()
+	defaults := map[string]string{
+		"_journal_mode": "WAL",
+		"_busy_timeout": "5000",
+		"_foreign_keys": "on",
+		"_txlock":       "immediate",
+	}
+	for key, value := range defaults {
+		if !query.Has(key) {
+			query.Set(key, value)
 		}
 	}
+	return "file:" + path + "?" + query.Encode(), nil
+}
+
+// setUpPostgresDB connects to the database described by the separate Postgres settings, through
+// openDatabaseURL so that it's opened the same way as DATABASE_URL
+func setUpPostgresDB(cfg DatabaseConfig) error {
+	return openDatabaseURL(cfg.postgresURL())
+}
+
+// postgresURL describes the separate Postgres settings as a URL. The driver's default sslmode
+// applies unless SSLMode is set, as it does for DATABASE_URL.
+func (cfg DatabaseConfig) postgresURL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.User, cfg.Password),
+		Host:   cfg.Host,
+		Path:   "/" + cfg.Name,
+	}
+	if cfg.Port != "" {
+		u.Host = net.JoinHostPort(cfg.Host, cfg.Port)
+	}
+	if cfg.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {cfg.SSLMode}}.Encode()
+	}
+	return u.String()
 }
 
-func fetchCachedFilms(filmSlugs []string) []Film {
+func setUpInMemorySQLiteDB() error {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to open in-memory SQLite database: %w", err)
+	}
+	cacheDB = db
+
+	// Each connection to ":memory:" opens a separate database, so pin the pool to one
+	if sqlDB, err := cacheDB.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return nil
+}
+
+// migrateDB brings the schema up to date, refusing to touch a schema from a newer build
+func migrateDB() error {
+	if cacheDB == nil {
+		return nil
+	}
+	if os.Getenv("FORCE_DB_RESET") == "true" {
+		slog.Warn("FORCE_DB_RESET is no longer supported, run \"migrate to 0\" to reset the database instead")
+	}
+	return migrateTo(cacheDB, latestMigrationVersion())
+}
+
+func (a *analyzer) fetchCachedFilms(filmSlugs []string) []Film {
 	cacheHits := []Film{}
 
-	if cacheDB != nil {
+	if a.db != nil {
 		batchCacheHits := []Film{}
-		batchSize := 500
+		batchSize := a.config.FilmCache.LookupBatchSize
 
 		for i := 0; i < len(filmSlugs); i += batchSize {
 			end := min(i+batchSize, len(filmSlugs))
 			batchFilmSlugs := filmSlugs[i:end]
 
 			batchCacheHits = []Film{} // Clear previous batch results
-			cacheDB.Preload("Cast").Where("slug IN (?)", batchFilmSlugs).Find(&batchCacheHits)
+			a.db.Preload("Cast").Where("slug IN (?)", batchFilmSlugs).Find(&batchCacheHits)
 
 			cacheHits = append(cacheHits, batchCacheHits...)
 		}
 
-		slog.Info("Finished fetching cached films", "numHits", len(cacheHits))
+		a.logger.Info("Finished fetching cached films", "numHits", len(cacheHits))
+		filmCacheLookups.add(float64(len(cacheHits)), "hit")
+		filmCacheLookups.add(float64(len(filmSlugs)-len(cacheHits)), "miss")
 	}
 
 	return cacheHits
 }
 
-func fetchCachedFilm(filmSlug string) (Film, bool) {
-	if cacheDB != nil {
+func (a *analyzer) fetchCachedFilm(filmSlug string) (Film, bool) {
+	if a.db != nil {
 		var films []Film
-		result := cacheDB.Preload("Cast").Where("slug = ?", filmSlug).Limit(1).Find(&films)
+		result := a.db.Preload("Cast").Where("slug = ?", filmSlug).Limit(1).Find(&films)
 		if result.Error == nil && len(films) > 0 {
 			films := films[0]
-			slog.Info("Film cache hit", "filmSlug", films.Slug)
+			a.logger.Info("Film cache hit", "filmSlug", films.Slug)
 			return films, true
 		}
 	}
@@ -96,9 +251,113 @@ func fetchCachedFilm(filmSlug string) (Film, bool) {
 	return Film{}, false
 }
 
-func saveFilmToCache(films Film) {
-	if cacheDB != nil {
-		slog.Info("Saving film to cache", "filmSlug", films.Slug)
-		cacheDB.Create(&films)
+// saveFilmToCache upserts a film on its slug and replaces its cast in one transaction, keeping
+// unchanged credits and only adding, updating or removing the credits that differ
+func (a *analyzer) saveFilmToCache(film Film) error {
+	if a.db == nil {
+		return nil
+	}
+
+	a.logger.Info("Saving film to cache", "filmSlug", film.Slug)
+	setFilmExpiry(&film, a.config.FilmCache)
+	var changes castChanges
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = saveFilm(tx, film)
+		return err
+	})
+	if err != nil {
+		a.logger.Error("Failed to save film to cache", "filmSlug", film.Slug, "error", err)
+		return err
+	}
+	if changes.hadCast {
+		a.logger.Info("Updated cached cast", "filmSlug", film.Slug,
+			"numAdded", changes.added, "numUpdated", changes.updated, "numRemoved", changes.removed)
+	}
+	return nil
+}
+
+// castChanges counts how saveFilm changed a film's cached cast
+type castChanges struct {
+	hadCast                 bool // whether the film had a cached cast before
+	added, updated, removed int
+}
+
+// saveFilm upserts film within tx, keeping its FetchedAt and ExpiresAt as they are
+func saveFilm(tx *gorm.DB, film Film) (castChanges, error) {
+	row := Film{
+		Slug:        film.Slug,
+		Title:       film.Title,
+		ReleaseYear: film.ReleaseYear,
+		FetchedAt:   film.FetchedAt,
+		ExpiresAt:   film.ExpiresAt,
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "slug"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "release_year", "fetched_at", "expires_at", "updated_at", "deleted_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		return castChanges{}, err
+	}
+
+	// The upserted row's ID isn't returned by every driver when it already existed
+	var filmID uint
+	if err := tx.Unscoped().Model(&Film{}).Where("slug = ?", film.Slug).Pluck("id", &filmID).Error; err != nil {
+		return castChanges{}, err
+	}
+
+	var cachedCast []Credit
+	if err := tx.Unscoped().Where("film_id = ?", filmID).Find(&cachedCast).Error; err != nil {
+		return castChanges{}, err
+	}
+	existing := make(map[string]Credit)
+	for _, credit := range cachedCast {
+		if _, found := existing[credit.Actor]; found {
+			// Left over from an earlier duplicate save
+			if err := tx.Unscoped().Delete(&credit).Error; err != nil {
+				return castChanges{}, err
+			}
+			continue
+		}
+		existing[credit.Actor] = credit
+	}
+
+	changes := castChanges{hadCast: len(cachedCast) > 0}
+	for _, credit := range film.Cast {
+		cachedCredit, found := existing[credit.Actor]
+		delete(existing, credit.Actor)
+		if !found {
+			newCredit := Credit{Actor: credit.Actor, Roles: credit.Roles, FilmID: filmID}
+			if err := tx.Create(&newCredit).Error; err != nil {
+				return castChanges{}, err
+			}
+			changes.added++
+		} else if cachedCredit.Roles != credit.Roles || cachedCredit.DeletedAt.Valid {
+			err := tx.Unscoped().Model(&cachedCredit).Updates(map[string]any{"roles": credit.Roles, "deleted_at": nil}).Error
+			if err != nil {
+				return castChanges{}, err
+			}
+			changes.updated++
+		}
+	}
+	for _, credit := range existing {
+		if err := tx.Unscoped().Delete(&credit).Error; err != nil {
+			return castChanges{}, err
+		}
+	}
+
+	changes.removed = len(existing)
+	return changes, nil
+}
+
+// closeDB closes the connection pool on shutdown, after which cacheDB can't be used
+func closeDB() error {
+	if cacheDB == nil {
+		return nil
+	}
+	sqlDB, err := cacheDB.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Close()
 }
@@ -1,6 +1,8 @@
 package actorfreq
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"testing"
@@ -21,9 +23,8 @@ func initDatabaseBenchmark() {
 }
 
 func prepareDatabaseBenchmarkDB() {
-	cacheDB.Migrator().DropTable(&Film{})
-	cacheDB.Migrator().DropTable(&Credit{})
-	setUpGORMTables()
+	migrateTo(cacheDB, 0)
+	migrateDB()
 }
 
 func runDatabaseBenchmark(b *testing.B) {
@@ -36,11 +37,11 @@ func runDatabaseBenchmark(b *testing.B) {
 	}
 
 	// Seed cache
-	fetchActors("pablo_agave", rc, nil)
+	defaultAnalyzer().fetchActors(context.Background(), "pablo_agave", rc, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		fetchActors("pablo_agave", rc, nil)
+		defaultAnalyzer().fetchActors(context.Background(), "pablo_agave", rc, nil)
 	}
 }
 
@@ -48,7 +49,7 @@ func BenchmarkPostgresDBPerformance(b *testing.B) {
 	initDatabaseBenchmark()
 
 	os.Setenv("ACTORFREQ_DB_NAME", "actorfreq_test")
-	setUpPostgresDB()
+	setUpPostgresDB(configFromEnv().Database)
 
 	runDatabaseBenchmark(b)
 }
@@ -60,3 +61,52 @@ func BenchmarkInMemorySQLiteDBPerformance(b *testing.B) {
 
 	runDatabaseBenchmark(b)
 }
+
+// seedAggregationBenchmark caches numFilms films with 20 credits each from a pool of 500 actors
+func seedAggregationBenchmark(numFilms int) []string {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	filmSlugs := []string{}
+	films := []Film{}
+	for i := 0; i < numFilms; i++ {
+		film := Film{Slug: fmt.Sprintf("film-%d", i), Title: fmt.Sprintf("Film %d", i)}
+		for j := 0; j < 20; j++ {
+			roles := fmt.Sprintf("Role %d", j)
+			if j%5 == 0 {
+				roles += " (voice)"
+			}
+			film.Cast = append(film.Cast, Credit{Actor: fmt.Sprintf("Actor %d", (i*7+j*31)%500), Roles: roles})
+		}
+		films = append(films, film)
+		filmSlugs = append(filmSlugs, film.Slug)
+	}
+	cacheDB.CreateInBatches(&films, 100)
+
+	return filmSlugs
+}
+
+func BenchmarkGoAggregation(b *testing.B) {
+	initDatabaseBenchmark()
+	filmSlugs := seedAggregationBenchmark(2000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		films := defaultAnalyzer().fetchCachedFilms(filmSlugs)
+		actors := make(map[string]*actorDetails)
+		for _, film := range films {
+			addFilmCredits(actors, film, []string{"voice"})
+		}
+		cleanActors(actors)
+	}
+}
+
+func BenchmarkSQLAggregation(b *testing.B) {
+	initDatabaseBenchmark()
+	filmSlugs := seedAggregationBenchmark(2000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		defaultAnalyzer().aggregateCachedActors(filmSlugs, []string{"voice"})
+	}
+}
@@ -0,0 +1,18 @@
+// Package actorfreq finds the actors appearing most often in a Letterboxd user's films.
+//
+// Programs that want results use a Client:
+//
+//	client := actorfreq.NewClient(actorfreq.WithDB(db))
+//	result, err := client.Analyze(ctx, "someone", actorfreq.Options{TopNMovies: 100})
+//
+// Programs that want the web app mount a Server, which serves the homepage, the JSON API and
+// the background precaching, on their own router.
+//
+// # Compatibility
+//
+// The package follows semantic versioning. Within a major version, exported identifiers are
+// not removed or changed incompatibly, although structs such as Options, Progress and Features
+// may gain fields, so construct them with field names. ErrInvalidOptions is the only error
+// value to compare against; the text of other errors and log messages may change. The HTTP
+// API is versioned separately, under /api/v1/.
+package actorfreq
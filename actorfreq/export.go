@@ -0,0 +1,102 @@
+package actorfreq
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// exportFormat is a download served by exportHandler
+type exportFormat struct {
+	contentType string
+	extension   string
+}
+
+// exportFormats are the downloads offered under the results in index.html. letterboxd is a list
+// of one actor's films rather than the whole result.
+var exportFormats = map[string]exportFormat{
+	"csv":        {"text/csv; charset=utf-8", "csv"},
+	"json":       {"application/json", "json"},
+	"markdown":   {"text/markdown; charset=utf-8", "md"},
+	"letterboxd": {"text/csv; charset=utf-8", "csv"},
+}
+
+// exportFormatValues lists exportFormats in the order they're documented
+var exportFormatValues = []string{"csv", "json", "markdown", "letterboxd"}
+
+// exportHandler serves GET /api/v1/users/{username}/export/{format} as a file to download, taking
+// the same options as the actors endpoint so that it's served from the same request cache entry
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	startRequest()
+	defer finishRequest()
+
+	formatName := r.PathValue("format")
+	format, found := exportFormats[formatName]
+	if !found {
+		writeFieldError(w, r, &fieldError{
+			Field:   "format",
+			Message: fmt.Sprintf("%q is not one of %s", formatName, strings.Join(exportFormatValues, ", ")),
+		})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_form", "Failed to parse query parameters")
+		return
+	}
+	username := r.PathValue("username")
+
+	// The actor isn't part of the analysis, so leave it out of the request cache key
+	actorName := r.Form.Get("actor")
+	r.Form.Del("actor")
+	if formatName == "letterboxd" && actorName == "" {
+		writeFieldError(w, r, &fieldError{Field: "actor", Message: "is required for the letterboxd format"})
+		return
+	}
+
+	if err := validateRequestForm(r.Form); err != nil {
+		writeFieldError(w, r, err)
+		return
+	}
+
+	r.Form.Set("username", username)
+	actors, ok := s.getActorsOrWriteError(w, username, getRequestConfig(r.Form), r.Form.Encode())
+	if !ok {
+		return
+	}
+	result := newResult(username, actors)
+
+	w.Header().Set("Content-Type", format.contentType)
+	if formatName != "letterboxd" {
+		setAttachmentFilename(w, username+"-actors."+format.extension)
+		if err := writeResult(w, formatName, result, 0); err != nil {
+			s.logger.Error("Failed to write export", "username", username, "format", formatName, "error", err)
+		}
+		return
+	}
+
+	for _, actor := range result.Actors {
+		if strings.EqualFold(actor.Name, actorName) {
+			setAttachmentFilename(w, username+"-"+filenameSlug(actor.Name)+"."+format.extension)
+			if err := writeLetterboxdList(w, actor); err != nil {
+				s.logger.Error("Failed to write export", "username", username, "format", formatName, "error", err)
+			}
+			return
+		}
+	}
+	writeError(w, r, http.StatusNotFound, "actor_not_found",
+		fmt.Sprintf("%s isn't in more than one of %s's films", actorName, username))
+}
+
+func setAttachmentFilename(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// filenameSlug turns an actor's name into something safe to put in a filename, e.g. "tom-hanks"
+func filenameSlug(name string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
@@ -0,0 +1,104 @@
+package actorfreq
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportHandler(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	httpCalls := make(map[string]int)
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		httpCalls[req.URL.String()]++
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/exportUser/films/by/release/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="cast-away" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1>` +
+				`<a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>` +
+				`<a href="/actor/tom-hanks" title="Josh (adult)">Tom Hanks</a>`
+		case "https://letterboxd.com/film/cast-away/":
+			responseString = `<h1 class="filmtitle">Cast Away</h1><a href="/actor/tom-hanks" title="Chuck Noland">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	server := NewServer()
+
+	cases := []struct {
+		target              string
+		expectedStatus      int
+		expectedType        string
+		expectedDisposition string
+		expectedBody        string
+	}{
+		{
+			"/api/v1/users/exportUser/export/csv?sortStrategy=release",
+			http.StatusOK, "text/csv; charset=utf-8", `attachment; filename=exportUser-actors.csv`,
+			"actor,count,film_slug,title,role\n" +
+				"Tom Hanks,2,big,Big,Josh\n" +
+				"Tom Hanks,2,big,Big,Josh (adult)\n" +
+				"Tom Hanks,2,cast-away,Cast Away,Chuck Noland\n",
+		},
+		{
+			"/api/v1/users/exportUser/export/json?sortStrategy=release",
+			http.StatusOK, "application/json", `attachment; filename=exportUser-actors.json`,
+			"{\n  \"username\": \"exportUser\",\n  \"actors\": [\n    {\n      \"name\": \"Tom Hanks\",\n      \"count\": 2,",
+		},
+		{
+			"/api/v1/users/exportUser/export/markdown?sortStrategy=release",
+			http.StatusOK, "text/markdown; charset=utf-8", `attachment; filename=exportUser-actors.md`,
+			"| # | Actor | Films | Titles |\n|---:|---|---:|---|\n" +
+				"| 1 | Tom Hanks | 2 | [Big](https://letterboxd.com/film/big/), [Cast Away](https://letterboxd.com/film/cast-away/) |\n",
+		},
+		{
+			"/api/v1/users/exportUser/export/letterboxd?sortStrategy=release&actor=tom+hanks",
+			http.StatusOK, "text/csv; charset=utf-8", `attachment; filename=exportUser-tom-hanks.csv`,
+			"Position,Title,LetterboxdURI\n" +
+				"1,Big,https://letterboxd.com/film/big/\n" +
+				"2,Cast Away,https://letterboxd.com/film/cast-away/\n",
+		},
+		{"/api/v1/users/exportUser/export/letterboxd?sortStrategy=release&actor=Meg+Ryan", http.StatusNotFound, "", "", ""},
+		{"/api/v1/users/exportUser/export/letterboxd?sortStrategy=release", http.StatusBadRequest, "", "", ""},
+		{"/api/v1/users/exportUser/export/xlsx?sortStrategy=release", http.StatusBadRequest, "", "", ""},
+		{"/api/v1/users/exportUser/export/csv?sortStrategy=bogus", http.StatusBadRequest, "", "", ""},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.target, nil))
+		if rec.Code != c.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expectedStatus, rec.Code, rec.Body.String())
+			continue
+		}
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != c.expectedType {
+			t.Errorf("%s: expected Content-Type %q, got %q", c.target, c.expectedType, contentType)
+		}
+		if disposition := rec.Header().Get("Content-Disposition"); disposition != c.expectedDisposition {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", c.target, c.expectedDisposition, disposition)
+		}
+		if !strings.HasPrefix(rec.Body.String(), c.expectedBody) {
+			t.Errorf("%s: expected body starting with %q, got %q", c.target, c.expectedBody, rec.Body.String())
+		}
+	}
+
+	// Every export, whatever the actor, is served from the same request cache entry
+	if httpCalls["https://letterboxd.com/exportUser/films/by/release/page/1"] != 1 {
+		t.Errorf("Expected the user's films to be listed once, got %v", httpCalls)
+	}
+}
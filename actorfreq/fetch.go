@@ -1,12 +1,9 @@
 package actorfreq
 
 import (
-	"log/slog"
-	"net/http"
+	"context"
 	"sort"
 	"strings"
-	"sync"
-	"sync/atomic"
 )
 
 type actorDetails struct {
@@ -20,69 +17,81 @@ type movieDetails struct {
 	Roles    string
 }
 
-func fetchActors(username string, rc requestConfig, w *http.ResponseWriter) []actorDetails {
-	filmSlugs := fetchFilmSlugs(username, rc.sortStrategy)
+// fetchActors counts the actors across username's films, telling progress how it's getting on
+func (a *analyzer) fetchActors(ctx context.Context, username string, rc requestConfig, progress ProgressReporter) ([]actorDetails, error) {
+	tracker := newProgressTracker(progress)
+	tracker.setPhase(PhaseListing)
+	filmSlugs, err := a.fetchFilmSlugs(ctx, username, rc.sortStrategy, tracker)
+	if err != nil {
+		return nil, err
+	}
 
 	if rc.topNMovies > 0 && rc.topNMovies < len(filmSlugs) {
 		filmSlugs = filmSlugs[:rc.topNMovies]
 	}
 
-	if w != nil {
-		sendMapAsSSEData(*w, map[string]int{
-			"total": len(filmSlugs),
-		})
+	tracker.setTotal(len(filmSlugs))
+	if a.sqlAggregation {
+		if actors, ok := a.aggregateCachedActors(filmSlugs, rc.roleFilters); ok {
+			tracker.resolvedFromCache(len(filmSlugs))
+			tracker.setPhase(PhaseAggregating)
+			return actors, nil
+		}
 	}
 
-	films := getFilms(filmSlugs, w)
+	films, err := a.getFilms(ctx, filmSlugs, tracker)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker.setPhase(PhaseAggregating)
 	actors := make(map[string]*actorDetails)
 	for _, film := range films {
-		for _, credit := range film.Cast {
-			actor, found := actors[credit.Actor]
-			filteredRoles := filterRoles(credit.Roles, rc.roleFilters)
-			if filteredRoles != "" {
-				if !found {
-					actors[credit.Actor] = &actorDetails{Name: credit.Actor}
-					actor = actors[credit.Actor]
-				}
-				actor.Movies = append(actor.Movies, movieDetails{
-					FilmSlug: film.Slug,
-					Title:    film.Title,
-					Roles:    credit.Roles,
-				})
-			}
-		}
+		addFilmCredits(actors, film, rc.roleFilters)
 	}
 
 	cleanedActors := cleanActors(actors)
 
-	return cleanedActors
+	return cleanedActors, nil
 }
 
-func getFilms(filmSlugs []string, w *http.ResponseWriter) []Film {
-	cacheHits := fetchCachedFilms(filmSlugs)
-
-	progress := len(cacheHits)
-	if w != nil {
-		sendMapAsSSEData(*w, map[string]int{
-			"progress": progress,
-		})
+// addFilmCredits adds the film to each actor credited in it with roles surviving roleFilters
+func addFilmCredits(actors map[string]*actorDetails, film Film, roleFilters []string) {
+	for _, credit := range film.Cast {
+		actor, found := actors[credit.Actor]
+		filteredRoles := filterRoles(credit.Roles, roleFilters)
+		if filteredRoles != "" {
+			if !found {
+				actors[credit.Actor] = &actorDetails{Name: credit.Actor}
+				actor = actors[credit.Actor]
+			}
+			actor.Movies = append(actor.Movies, movieDetails{
+				FilmSlug: film.Slug,
+				Title:    film.Title,
+				Roles:    credit.Roles,
+			})
+		}
 	}
+}
+
+func (a *analyzer) getFilms(ctx context.Context, filmSlugs []string, tracker *progressTracker) ([]Film, error) {
+	cacheHits := a.fetchCachedFilms(filmSlugs)
 
 	filmsMap := make(map[string]Film)
 	for _, film := range cacheHits {
 		filmsMap[film.Slug] = film
+		tracker.resolved(film, true)
 	}
 
 	for _, filmSlug := range filmSlugs {
 		_, exists := filmsMap[filmSlug]
 		if !exists {
-			filmsMap[filmSlug] = fetchFilm(filmSlug)
-			if w != nil {
-				progress++
-				sendMapAsSSEData(*w, map[string]int{
-					"progress": progress,
-				})
+			film, err := a.fetchFilm(ctx, filmSlug)
+			if err != nil {
+				return nil, err
 			}
+			filmsMap[filmSlug] = film
+			tracker.resolved(film, false)
 		}
 	}
 
@@ -91,7 +100,7 @@ func getFilms(filmSlugs []string, w *http.ResponseWriter) []Film {
 		films = append(films, filmsMap[filmSlug])
 	}
 
-	return films
+	return films, nil
 }
 
 func filterRoles(roles string, roleFilters []string) string {
@@ -132,45 +141,3 @@ func cleanActors(actors map[string]*actorDetails) []actorDetails {
 
 	return cleanedActors
 }
-
-var filmSlugsToPrecacheMutex sync.Mutex
-var filmSlugsToPrecache = []string{}
-var followedUsersToPrecacheForMutex sync.Mutex
-var followedUsersToPrecacheFor = []string{}
-
-func precacheFollowing() {
-	precacheFollowingStarted.Store(true)
-	if cacheDB != nil {
-		defer precacheFollowingStarted.Store(false)
-		for {
-			if atomic.LoadInt32(&activeRequests) == 0 {
-				if len(filmSlugsToPrecache) != 0 {
-					slug := filmSlugsToPrecache[0]
-
-					_, exists := fetchCachedFilm(slug)
-					if !exists {
-						slog.Info("Precaching followedUser film slug", "slug", slug)
-						fetchFilm(slug)
-					}
-
-					filmSlugsToPrecacheMutex.Lock()
-					filmSlugsToPrecache = filmSlugsToPrecache[1:]
-					filmSlugsToPrecacheMutex.Unlock()
-				} else if len(followedUsersToPrecacheFor) != 0 {
-					followedUser := followedUsersToPrecacheFor[0]
-					slog.Info("Fetching followedUser film slugs", "followedUser", followedUser)
-
-					filmSlugsToPrecacheMutex.Lock()
-					filmSlugsToPrecache = fetchFilmSlugs(followedUser, "release")
-					filmSlugsToPrecacheMutex.Unlock()
-
-					followedUsersToPrecacheForMutex.Lock()
-					followedUsersToPrecacheFor = followedUsersToPrecacheFor[1:]
-					followedUsersToPrecacheForMutex.Unlock()
-				} else {
-					break
-				}
-			}
-		}
-	}
-}
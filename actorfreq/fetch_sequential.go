@@ -1,66 +1,53 @@
 package actorfreq
 
 import (
-	"log/slog"
-	"net/http"
+	"context"
 
 	"gorm.io/gorm"
 )
 
-func fetchActorsSequentially(username string, rc requestConfig, w *http.ResponseWriter) []actorDetails {
-	filmSlugs := fetchFilmSlugs(username, rc.sortStrategy)
+func (a *analyzer) fetchActorsSequentially(ctx context.Context, username string, rc requestConfig, progress ProgressReporter) ([]actorDetails, error) {
+	tracker := newProgressTracker(progress)
+	tracker.setPhase(PhaseListing)
+	filmSlugs, err := a.fetchFilmSlugs(ctx, username, rc.sortStrategy, tracker)
+	if err != nil {
+		return nil, err
+	}
 
 	if rc.topNMovies > 0 && rc.topNMovies < len(filmSlugs) {
 		filmSlugs = filmSlugs[:rc.topNMovies]
 	}
 
-	if w != nil {
-		sendMapAsSSEData(*w, map[string]int{
-			"total": len(filmSlugs),
-		})
-	}
-
+	tracker.setTotal(len(filmSlugs))
 	actors := make(map[string]*actorDetails)
-	for i, slug := range filmSlugs {
-		film := getFilm(slug)
-		for _, credit := range film.Cast {
-			actor, found := actors[credit.Actor]
-			filteredRoles := filterRoles(credit.Roles, rc.roleFilters)
-			if filteredRoles != "" {
-				if !found {
-					actors[credit.Actor] = &actorDetails{Name: credit.Actor}
-					actor = actors[credit.Actor]
-				}
-				actor.Movies = append(actor.Movies, movieDetails{
-					FilmSlug: film.Slug,
-					Title:    film.Title,
-					Roles:    credit.Roles,
-				})
-			}
-		}
-
-		if w != nil {
-			sendMapAsSSEData(*w, map[string]int{
-				"progress": i + 1,
-			})
+	for _, slug := range filmSlugs {
+		film, cached, err := a.getFilm(ctx, slug)
+		if err != nil {
+			return nil, err
 		}
+		addFilmCredits(actors, film, rc.roleFilters)
+		tracker.resolved(film, cached)
 	}
 
+	tracker.setPhase(PhaseAggregating)
 	cleanedActors := cleanActors(actors)
 
-	return cleanedActors
+	return cleanedActors, nil
 }
 
-func getFilm(slug string) Film {
+func (a *analyzer) getFilm(ctx context.Context, slug string) (Film, bool, error) {
 	var films []Film
 	var result *gorm.DB
-	if cacheDB != nil {
-		result = cacheDB.Preload("Cast").Where("slug = ?", slug).Limit(1).Find(&films)
+	if a.db != nil {
+		result = a.db.Preload("Cast").Where("slug = ?", slug).Limit(1).Find(&films)
 	}
 	if result == nil || result.Error != nil || result.RowsAffected == 0 {
-		slog.Info("Sequential cache miss", "slug", slug)
-		return fetchFilm(slug)
+		a.logger.Info("Sequential cache miss", "slug", slug)
+		filmCacheLookups.inc("miss")
+		film, err := a.fetchFilm(ctx, slug)
+		return film, false, err
 	}
-	slog.Info("Sequential cache hit", "slug", slug)
-	return films[0]
+	a.logger.Info("Sequential cache hit", "slug", slug)
+	filmCacheLookups.inc("hit")
+	return films[0], true, nil
 }
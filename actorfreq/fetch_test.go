@@ -1,6 +1,7 @@
 package actorfreq
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"reflect"
@@ -52,6 +53,7 @@ func TestFetchActors(t *testing.T) {
 	})
 
 	setUpInMemorySQLiteDB()
+	migrateDB()
 	cacheDB.Create(
 		&Film{
 			Slug:  "saving-private-ryan",
@@ -77,7 +79,10 @@ func TestFetchActors(t *testing.T) {
 		topNMovies:   3,
 		roleFilters:  []string{"uncredited"},
 	}
-	actualActors := fetchActors("testUser", rc, nil)
+	actualActors, err := defaultAnalyzer().fetchActors(context.Background(), "testUser", rc, nil)
+	if err != nil {
+		t.Fatalf("Failed to fetch actors: %v", err)
+	}
 
 	expectedActors := []actorDetails{
 		{
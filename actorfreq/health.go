@@ -0,0 +1,200 @@
+package actorfreq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultListenAddr      = ":8080"
+	defaultShutdownDelay   = 5 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+	readinessCheckTimeout  = 2 * time.Second
+)
+
+// shuttingDown is set once shutdown begins, so load balancers stop sending traffic our way
+var shuttingDown atomic.Bool
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthzHandler reports that the process is alive, without checking its dependencies
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// readyzHandler reports whether we can serve requests: the database is reachable and migrated,
+// and we aren't shutting down. Running without a database is a supported configuration.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	status := healthStatus{Status: "ready", Checks: s.checkReadiness(ctx)}
+	for _, result := range status.Checks {
+		if result != "ok" && result != "disabled" {
+			status.Status = "unavailable"
+		}
+	}
+	if status.Status != "ready" {
+		writeJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) checkReadiness(ctx context.Context) map[string]string {
+	checks := map[string]string{"shutdown": "ok"}
+	if shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+	}
+
+	if s.db == nil {
+		checks["database"] = "disabled"
+		checks["migrations"] = "disabled"
+		return checks
+	}
+
+	sqlDB, err := s.db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		s.logger.Warn("Readiness check failed", "check", "database", "error", err)
+		checks["database"] = "unreachable"
+		checks["migrations"] = "unknown"
+		return checks
+	}
+	checks["database"] = "ok"
+
+	version, err := currentMigrationVersion(s.db.WithContext(ctx))
+	switch {
+	case err != nil:
+		s.logger.Warn("Readiness check failed", "check", "migrations", "error", err)
+		checks["migrations"] = "unknown"
+	case version != latestMigrationVersion():
+		checks["migrations"] = fmt.Sprintf("at version %d, expected %d", version, latestMigrationVersion())
+	default:
+		checks["migrations"] = "ok"
+	}
+	return checks
+}
+
+// newHTTPServer has no write timeout, since analyses stream their progress for as long as they take
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// serveUntilSignalled serves until server fails or we receive SIGINT or SIGTERM, then shuts down
+func serveUntilSignalled(server *http.Server, s *Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		stop() // a second signal kills the process
+	}
+
+	return shutdown(server, s, s.config.ShutdownDelay, s.config.ShutdownTimeout)
+}
+
+// shutdown reports that we're shutting down on /readyz for delay, so load balancers can stop
+// sending traffic our way, then stops accepting connections and waits up to timeout for s to stop
+// before closing the database set up by SetUpDB, if s uses it. A database given to s with WithDB
+// belongs to whoever opened it.
+func shutdown(server *http.Server, s *Server, delay time.Duration, timeout time.Duration) error {
+	slog.Info("Shutting down", "delay", delay, "timeout", timeout, "activeRequests", atomic.LoadInt32(&activeRequests))
+	shuttingDown.Store(true)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Stop(ctx) }()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("draining connections: %w", err))
+		server.Close()
+	}
+	stopErr := <-stopped
+	if stopErr != nil {
+		errs = append(errs, stopErr)
+	}
+
+	switch {
+	case s.db == nil || s.db != cacheDB:
+	case stopErr != nil:
+		slog.Warn("Leaving the database open for analyses and precache tasks that are still running")
+	default:
+		if err := closeDB(); err != nil {
+			errs = append(errs, fmt.Errorf("closing database: %w", err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		slog.Warn("Shut down before everything finished", "error", err)
+		return err
+	}
+	slog.Info("Shut down cleanly")
+	return nil
+}
+
+// Stop stops the precache workers and waits until ctx is done for in-flight precache tasks and
+// active analyses, including background jobs, to finish, cancelling the server's analyses if ctx
+// is done first. Interrupted jobs and precache tasks stay persisted and are resumed by the next
+// Start. Analyses are counted across every server in the process, so stop the http.Server first
+// to keep new ones from starting.
+func (s *Server) Stop(ctx context.Context) error {
+	precacheStopped := make(chan error, 1)
+	go func() { precacheStopped <- s.precache.stop(ctx) }()
+
+	var errs []error
+	if err := waitForActiveRequests(ctx); err != nil {
+		s.cancelAnalyses()
+		errs = append(errs, fmt.Errorf("draining analyses: %w", err))
+	}
+	if err := <-precacheStopped; err != nil {
+		errs = append(errs, fmt.Errorf("stopping precache workers: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// waitForActiveRequests waits for analyses, which includes jobs that aren't tied to a connection
+func waitForActiveRequests(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&activeRequests) > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("Stopped waiting for active requests", "activeRequests", atomic.LoadInt32(&activeRequests))
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defer shuttingDown.Store(false)
+
+	server := NewServer()
+	get := func(target string) (int, healthStatus) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var status healthStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: failed to unmarshal %q: %v", target, rec.Body.String(), err)
+		}
+		return rec.Code, status
+	}
+
+	if code, status := get("/healthz"); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("Expected healthz to be ok, got %d %+v", code, status)
+	}
+	if code, status := get("/readyz"); code != http.StatusOK || status.Status != "ready" {
+		t.Errorf("Expected readyz to be ready, got %d %+v", code, status)
+	}
+
+	migrateTo(cacheDB, latestMigrationVersion()-1)
+	if code, status := get("/readyz"); code != http.StatusServiceUnavailable || status.Checks["migrations"] == "ok" {
+		t.Errorf("Expected readyz to be unavailable with pending migrations, got %d %+v", code, status)
+	}
+	migrateDB()
+
+	shuttingDown.Store(true)
+	if code, status := get("/readyz"); code != http.StatusServiceUnavailable || status.Checks["shutdown"] != "shutting down" {
+		t.Errorf("Expected readyz to be unavailable while shutting down, got %d %+v", code, status)
+	}
+	shuttingDown.Store(false)
+
+	closeDB()
+	if code, status := get("/readyz"); code != http.StatusServiceUnavailable || status.Checks["database"] != "unreachable" {
+		t.Errorf("Expected readyz to be unavailable with the database closed, got %d %+v", code, status)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected healthz to stay ok with the database closed, got %d", code)
+	}
+}
+
+func TestShutdownDrainsActiveRequests(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defer shuttingDown.Store(false)
+
+	s := NewServer()
+	s.precache.start()
+
+	started := make(chan struct{})
+	jobFinished := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startRequest()
+		defer finishRequest()
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	// An analysis running as a job outlives the request that created it
+	startRequest()
+	go func() {
+		defer close(jobFinished)
+		defer finishRequest()
+		time.Sleep(300 * time.Millisecond)
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := newHTTPServer(listener.Addr().String(), handler)
+	go httpServer.Serve(listener)
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-started
+
+	if err := shutdown(httpServer, s, 0, 5*time.Second); err != nil {
+		t.Fatalf("Expected a clean shutdown, got %v", err)
+	}
+	if body := <-responses; body != "done" {
+		t.Errorf("Expected the active request to finish, got %q", body)
+	}
+	select {
+	case <-jobFinished:
+	default:
+		t.Error("Expected shutdown to wait for the job to finish")
+	}
+	s.precache.mutex.Lock()
+	if !s.precache.stopping {
+		t.Error("Expected the precache workers to be stopped")
+	}
+	s.precache.mutex.Unlock()
+	if sqlDB, _ := cacheDB.DB(); sqlDB.Ping() == nil {
+		t.Error("Expected the database to be closed")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	defer shuttingDown.Store(false)
+
+	startRequest()
+	defer finishRequest()
+
+	s := NewServer()
+	httpServer := newHTTPServer("127.0.0.1:0", http.NotFoundHandler())
+	if err := shutdown(httpServer, s, 0, 100*time.Millisecond); err == nil {
+		t.Error("Expected an error when an analysis outlives the shutdown deadline")
+	}
+	if s.analyses.Err() == nil {
+		t.Error("Expected the server's analyses to be cancelled at the deadline")
+	}
+	if sqlDB, _ := cacheDB.DB(); sqlDB.Ping() != nil {
+		t.Error("Expected the database to be left open for the analysis")
+	}
+}
+
+func TestShutdownLeavesOwnDBOpen(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	defer shuttingDown.Store(false)
+	db := cacheDB
+	setUpInMemorySQLiteDB()
+
+	httpServer := newHTTPServer("127.0.0.1:0", http.NotFoundHandler())
+	if err := shutdown(httpServer, NewServer(WithDB(db)), 0, time.Second); err != nil {
+		t.Fatalf("Expected a clean shutdown, got %v", err)
+	}
+	for name, db := range map[string]*gorm.DB{"given": db, "global": cacheDB} {
+		if sqlDB, _ := db.DB(); sqlDB.Ping() != nil {
+			t.Errorf("Expected the %s database to be left open", name)
+		}
+	}
+}
+
+func TestShutdownReportsNotReadyBeforeClosing(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defer shuttingDown.Store(false)
+
+	s := NewServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := newHTTPServer(listener.Addr().String(), s)
+	go httpServer.Serve(listener)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- shutdown(httpServer, s, 300*time.Millisecond, time.Second) }()
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatalf("Expected to still be listening during the shutdown delay, got %v", err)
+	}
+	var status healthStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || status.Checks["shutdown"] != "shutting down" {
+		t.Errorf("Expected readyz to report shutting down, got %d %+v", resp.StatusCode, status)
+	}
+
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
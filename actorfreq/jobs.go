@@ -0,0 +1,302 @@
+package actorfreq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	jobStatusQueued  = "queued"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusFailed  = "failed"
+)
+
+// Job is a background analysis whose state is persisted so it survives reconnects and restarts
+type Job struct {
+	ID        string `gorm:"primaryKey"`
+	Username  string
+	Params    string // URL-encoded form values, also used as the request cache key
+	Status    string `gorm:"index"`
+	Total     int
+	Progress  int
+	Result    string // JSON-encoded []actorDetails
+	Error     string
+	Seq       int64 // incremented on every update, used as the SSE event ID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type jobResponse struct {
+	ID       string          `json:"id"`
+	Status   string          `json:"status"`
+	Total    int             `json:"total"`
+	Progress int             `json:"progress"`
+	Actors   json.RawMessage `json:"actors,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func (job Job) response() jobResponse {
+	response := jobResponse{
+		ID:       job.ID,
+		Status:   job.Status,
+		Total:    job.Total,
+		Progress: job.Progress,
+		Error:    job.Error,
+	}
+	if job.Result != "" {
+		response.Actors = json.RawMessage(job.Result)
+	}
+	return response
+}
+
+func (job Job) finished() bool {
+	return job.Status == jobStatusDone || job.Status == jobStatusFailed
+}
+
+var jobEventsPollInterval = 500 * time.Millisecond
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) createJob(username string, form url.Values) (Job, error) {
+	job := Job{
+		ID:       newJobID(),
+		Username: username,
+		Params:   form.Encode(),
+		Status:   jobStatusQueued,
+	}
+	if err := s.db.Create(&job).Error; err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// fetchJob loads a job, reporting whether it exists separately from whether loading it failed
+func (s *Server) fetchJob(id string) (Job, bool, error) {
+	var jobs []Job
+	if err := s.db.Where("id = ?", id).Limit(1).Find(&jobs).Error; err != nil {
+		return Job{}, false, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, false, nil
+	}
+	return jobs[0], true, nil
+}
+
+func (s *Server) updateJob(id string, values map[string]any) {
+	values["seq"] = gorm.Expr("seq + 1")
+	if err := s.db.Model(&Job{}).Where("id = ?", id).Updates(values).Error; err != nil {
+		s.logger.Error("Failed to update job", "jobID", id, "error", err)
+	}
+}
+
+func (s *Server) runJob(job Job) {
+	startRequest()
+	defer finishRequest()
+
+	form, err := url.ParseQuery(job.Params)
+	if err != nil {
+		s.failJob(job.ID, err)
+		return
+	}
+
+	s.logger.Info("Running job", "jobID", job.ID, "username", job.Username)
+	s.updateJob(job.ID, map[string]any{
+		"status":   jobStatusRunning,
+		"total":    0,
+		"progress": 0,
+	})
+
+	rc := getRequestConfig(form)
+	actors, err := s.getActors(s.analyses, job.Username, rc, job.Params, &jobProgress{server: s, jobID: job.ID})
+	if s.analyses.Err() != nil {
+		s.logger.Warn("Job interrupted by shutdown", "jobID", job.ID)
+		return // left running for the next Start to resume
+	}
+	if err != nil {
+		s.failJob(job.ID, err)
+		return
+	}
+
+	result, err := json.Marshal(actors)
+	if err != nil {
+		s.failJob(job.ID, err)
+		return
+	}
+	s.updateJob(job.ID, map[string]any{
+		"status": jobStatusDone,
+		"result": string(result),
+	})
+	s.logger.Info("Job done", "jobID", job.ID, "numActors", len(actors))
+
+	s.queueFollowingForPrecache(job.Username)
+}
+
+func (s *Server) failJob(id string, err error) {
+	s.logger.Error("Job failed", "jobID", id, "error", err)
+	s.updateJob(id, map[string]any{
+		"status": jobStatusFailed,
+		"error":  err.Error(),
+	})
+}
+
+// resumeJobs restarts jobs that were queued or running when the server last stopped
+func (s *Server) resumeJobs() {
+	if s.db == nil {
+		return
+	}
+
+	var jobs []Job
+	s.db.Where("status IN (?)", []string{jobStatusQueued, jobStatusRunning}).Find(&jobs)
+	for _, job := range jobs {
+		s.logger.Info("Resuming interrupted job", "jobID", job.ID, "status", job.Status)
+		go s.runJob(job)
+	}
+}
+
+// jobProgress records the total and progress of an analysis on its job
+type jobProgress struct {
+	server *Server
+	jobID  string
+}
+
+func (jp *jobProgress) Report(p Progress) {
+	switch {
+	case p.Phase == PhaseResolving && p.Film == nil && p.Resolved == 0:
+		jp.server.updateJob(jp.jobID, map[string]any{"total": p.Total})
+	case p.Phase == PhaseResolving:
+		jp.server.updateJob(jp.jobID, map[string]any{"progress": p.Resolved})
+	}
+}
+
+func (s *Server) createJobHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Jobs require a database")
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_form", "Failed to parse form")
+		return
+	}
+
+	username := r.Form.Get("username")
+	if username == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_username", "Username is required")
+		return
+	}
+
+	if err := validateRequestForm(r.Form); err != nil {
+		writeFieldError(w, r, err)
+		return
+	}
+
+	job, err := s.createJob(username, r.Form)
+	if err != nil {
+		s.logger.Error("Failed to create job", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "database_error", "Failed to create job")
+		return
+	}
+	go s.runJob(job)
+
+	writeJSON(w, http.StatusAccepted, job.response())
+}
+
+func (s *Server) jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Jobs require a database")
+		return
+	}
+
+	job, found, err := s.fetchJob(r.PathValue("id"))
+	if err != nil {
+		s.logger.Error("Failed to load job", "jobID", r.PathValue("id"), "error", err)
+		writeError(w, r, http.StatusInternalServerError, "database_error", "Failed to load job")
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.response())
+}
+
+// jobEventNames names the event a job's snapshot is sent as, by the job's status
+var jobEventNames = map[string]string{
+	jobStatusQueued:  sseEventProgress,
+	jobStatusRunning: sseEventProgress,
+	jobStatusDone:    sseEventResult,
+	jobStatusFailed:  sseEventError,
+}
+
+// jobEventsHandler streams job snapshots as progress events until a result or error event, then
+// done. Each snapshot's ID is the job's Seq, so a client reconnecting with Last-Event-ID skips
+// those it has seen.
+func (s *Server) jobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Jobs require a database")
+		return
+	}
+
+	id := r.PathValue("id")
+	_, found, err := s.fetchJob(id)
+	if err != nil {
+		s.logger.Error("Failed to load job", "jobID", id, "error", err)
+		writeError(w, r, http.StatusInternalServerError, "database_error", "Failed to load job")
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+
+	lastEventID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		lastEventID = 0
+	}
+
+	stream := newSSEStream(w)
+	defer stream.close()
+
+	ticker := time.NewTicker(jobEventsPollInterval)
+	defer ticker.Stop()
+	for {
+		job, found, err := s.fetchJob(id)
+		if err != nil {
+			s.logger.Error("Failed to load job", "jobID", id, "error", err)
+			stream.send(sseEventError, sseMessageData{Message: "Failed to load job"})
+			return
+		}
+		if !found {
+			return
+		}
+
+		if job.Seq > lastEventID {
+			stream.sendWithID(job.Seq, jobEventNames[job.Status], job.response())
+			lastEventID = job.Seq
+		}
+
+		if job.finished() {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRunJob(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/jobUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="toy-story" />` +
+				`<div data-film-slug="cast-away" />`
+		case "https://letterboxd.com/film/toy-story/":
+			responseString = `<h1 class="filmtitle">Toy Story</h1>` +
+				`<a href="/actor/tom-hanks" title="Woody">Tom Hanks</a>`
+		case "https://letterboxd.com/film/cast-away/":
+			responseString = `<h1 class="filmtitle">Cast Away</h1>` +
+				`<a href="/actor/tom-hanks" title="Chuck Noland">Tom Hanks</a>`
+		default:
+			responseString = ""
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	server := NewServer()
+	job, err := server.createJob("jobUser", url.Values{"username": {"jobUser"}})
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	server.runJob(job)
+
+	job, found, err := server.fetchJob(job.ID)
+	if err != nil || !found {
+		t.Fatalf("Expected job %q to be persisted", job.ID)
+	}
+	if job.Status != jobStatusDone || job.Total != 2 || job.Progress != 2 {
+		t.Errorf("Expected done job with progress 2/2, got %s with progress %d/%d", job.Status, job.Progress, job.Total)
+	}
+
+	var actors []actorDetails
+	if err := json.Unmarshal([]byte(job.Result), &actors); err != nil {
+		t.Fatalf("Failed to unmarshal job result: %v", err)
+	}
+	if len(actors) != 1 || actors[0].Name != "Tom Hanks" || len(actors[0].Movies) != 2 {
+		t.Errorf("Expected Tom Hanks with 2 movies, got %v", actors)
+	}
+
+	// Resuming from the last event should only replay the final snapshot
+	req := httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	retry, events := parseSSEEvents(t, rec.Body.String())
+	if retry == "" || len(events) != 2 {
+		t.Fatalf("Expected a retry hint, a result and done, got %q", rec.Body.String())
+	}
+	if events[0].event != sseEventResult || events[0].id != int(job.Seq) || !strings.Contains(events[0].data, `"status":"done"`) {
+		t.Errorf("Expected the final snapshot as a result event, got %+v", events[0])
+	}
+	if events[1].event != sseEventDone {
+		t.Errorf("Expected the stream to end with done, got %+v", events[1])
+	}
+
+	// A failed job ends with an error event
+	server.failJob(job.ID, errors.New("letterboxd is down"))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID+"/events", nil))
+	if _, events := parseSSEEvents(t, rec.Body.String()); len(events) != 2 || events[0].event != sseEventError || events[1].event != sseEventDone {
+		t.Errorf("Expected an error event then done, got %q", rec.Body.String())
+	}
+}
+
+func TestJobErrorsAreJSON(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	unmigratedDB := cacheDB
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	for _, c := range []struct {
+		server         *Server
+		method, target string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{NewServer(), http.MethodGet, "/jobs/missing", http.StatusNotFound, "job_not_found"},
+		{NewServer(), http.MethodGet, "/jobs/missing/events", http.StatusNotFound, "job_not_found"},
+		{NewServer(WithDB(nil)), http.MethodPost, "/jobs?username=jobUser", http.StatusServiceUnavailable, "database_unavailable"},
+		// A database without the jobs table fails to load jobs rather than not finding them
+		{NewServer(WithDB(unmigratedDB)), http.MethodGet, "/jobs/missing", http.StatusInternalServerError, "database_error"},
+		{NewServer(WithDB(unmigratedDB)), http.MethodGet, "/jobs/missing/events", http.StatusInternalServerError, "database_error"},
+	} {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		c.server.ServeHTTP(rec, req)
+
+		var apiErr apiErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &apiErr)
+		if rec.Code != c.expectedStatus || apiErr.Error.Code != c.expectedCode {
+			t.Errorf("%s %s: expected %d %s, got %d %s", c.method, c.target, c.expectedStatus, c.expectedCode, rec.Code, rec.Body.String())
+		}
+	}
+}
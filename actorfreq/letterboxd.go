@@ -1,13 +1,14 @@
 package actorfreq
 
 import (
+	"context"
 	"fmt"
-	"log/slog"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"gorm.io/gorm"
@@ -15,16 +16,28 @@ import (
 
 var letterboxdMutex sync.Mutex
 
-func fetchLetterboxdDoc(url string) *goquery.Document {
+func (a *analyzer) fetchLetterboxdDoc(ctx context.Context, url string) (*goquery.Document, error) {
 	letterboxdMutex.Lock()
 	defer letterboxdMutex.Unlock()
-	return fetchDoc(url)
+	if err := ctx.Err(); err != nil {
+		return nil, err // given up on while waiting for the lock
+	}
+
+	start := time.Now()
+	doc, status, err := a.fetchDoc(ctx, url)
+	observeLetterboxdRequest(url, status, time.Since(start))
+	return doc, err
 }
 
-func fetchFilmSlugs(username string, sortStrategy string) []string {
+// fetchFilmSlugs lists username's films, telling tracker about each page listed
+func (a *analyzer) fetchFilmSlugs(ctx context.Context, username string, sortStrategy string, tracker *progressTracker) ([]string, error) {
 	// Fetch film slugs and page count from first page
-	doc := fetchFilmsPageDoc(username, sortStrategy, 1)
+	doc, err := a.fetchFilmsPageDoc(ctx, username, sortStrategy, 1)
+	if err != nil {
+		return nil, err
+	}
 	filmSlugsOnPage := extractFilmSlugs(doc)
+	tracker.listedPage()
 	filmSlugsByPage := map[int][]string{
 		1: filmSlugsOnPage,
 	}
@@ -34,15 +47,25 @@ func fetchFilmSlugs(username string, sortStrategy string) []string {
 		numPages = 1
 	}
 
-	// Fetch film slugs from remaining pages in parallel
+	// Fetch film slugs from remaining pages in parallel, keeping the first failure
 	var wg sync.WaitGroup
 	var mu sync.Mutex
+	var pageErr error
 	for page := 2; page <= numPages; page++ {
 		wg.Add(1)
 		go func(page int) {
 			defer wg.Done()
-			doc := fetchFilmsPageDoc(username, sortStrategy, page)
+			doc, err := a.fetchFilmsPageDoc(ctx, username, sortStrategy, page)
+			if err != nil {
+				mu.Lock()
+				if pageErr == nil {
+					pageErr = err
+				}
+				mu.Unlock()
+				return
+			}
 			filmSlugsOnPage := extractFilmSlugs(doc)
+			tracker.listedPage()
 			mu.Lock()
 			filmSlugsByPage[page] = filmSlugsOnPage
 			mu.Unlock()
@@ -51,12 +74,17 @@ func fetchFilmSlugs(username string, sortStrategy string) []string {
 
 	// Verify that we didn't miss any pages sequentially
 	for page := numPages + 1; true; page++ {
-		doc := fetchFilmsPageDoc(username, sortStrategy, page)
+		doc, err := a.fetchFilmsPageDoc(ctx, username, sortStrategy, page)
+		if err != nil {
+			wg.Wait()
+			return nil, err
+		}
 		filmSlugsOnPage := extractFilmSlugs(doc)
 		if len(filmSlugsOnPage) == 0 {
-			slog.Info("No more film slugs found", "username", username, "page", page)
+			a.logger.Info("No more film slugs found", "username", username, "page", page)
 			break
 		}
+		tracker.listedPage()
 		mu.Lock()
 		filmSlugsByPage[page] = filmSlugsOnPage
 		mu.Unlock()
@@ -64,6 +92,9 @@ func fetchFilmSlugs(username string, sortStrategy string) []string {
 
 	// Wait for goroutines to finish and aggregate results
 	wg.Wait()
+	if pageErr != nil {
+		return nil, pageErr
+	}
 
 	var pages []int
 	for page := range filmSlugsByPage {
@@ -71,17 +102,25 @@ func fetchFilmSlugs(username string, sortStrategy string) []string {
 	}
 	sort.Ints(pages)
 
+	// A film can show up on two pages if the list changes while it's being paged through, and
+	// counting it twice would count its actors twice
 	var filmSlugs []string
+	seen := make(map[string]bool)
 	for _, page := range pages {
-		filmSlugs = append(filmSlugs, filmSlugsByPage[page]...)
+		for _, filmSlug := range filmSlugsByPage[page] {
+			if !seen[filmSlug] {
+				seen[filmSlug] = true
+				filmSlugs = append(filmSlugs, filmSlug)
+			}
+		}
 	}
 
-	return filmSlugs
+	return filmSlugs, nil
 }
 
-func fetchFilmsPageDoc(username string, sortStrategy string, page int) *goquery.Document {
+func (a *analyzer) fetchFilmsPageDoc(ctx context.Context, username string, sortStrategy string, page int) (*goquery.Document, error) {
 	url := fmt.Sprintf("https://letterboxd.com/%s/films/by/%s/page/%d", username, sortStrategy, page)
-	return fetchLetterboxdDoc(url)
+	return a.fetchLetterboxdDoc(ctx, url)
 }
 
 func extractFilmSlugs(doc *goquery.Document) []string {
@@ -96,27 +135,50 @@ func extractFilmSlugs(doc *goquery.Document) []string {
 
 type Credit struct {
 	gorm.Model
-	Actor  string
+	Actor  string `gorm:"index:idx_credits_film_actor,priority:2"`
 	Roles  string
-	FilmID uint `gorm:"index"`
+	FilmID uint `gorm:"index;index:idx_credits_film_actor,priority:1"`
 }
 
 type Film struct {
 	gorm.Model
-	Slug  string `gorm:"uniqueIndex"`
-	Title string
-	Cast  []Credit
+	Slug        string `gorm:"uniqueIndex"`
+	Title       string
+	ReleaseYear int
+	Cast        []Credit
+	FetchedAt   time.Time
+	ExpiresAt   *time.Time `gorm:"index"` // nil for films cached before expiry was tracked
 }
 
-func fetchFilm(slug string) Film {
+func (a *analyzer) fetchFilm(ctx context.Context, slug string) (Film, error) {
+	film, err := a.scrapeFilm(ctx, slug)
+	if err != nil {
+		return Film{}, err
+	}
+
+	a.saveFilmToCache(film)
+
+	return film, nil
+}
+
+// scrapeFilm fetches a film's details from Letterboxd without caching them
+func (a *analyzer) scrapeFilm(ctx context.Context, slug string) (Film, error) {
 	url := fmt.Sprintf("https://letterboxd.com/film/%s/", slug)
-	doc := fetchLetterboxdDoc(url)
+	doc, err := a.fetchLetterboxdDoc(ctx, url)
+	if err != nil {
+		return Film{}, err
+	}
 
 	title := doc.Find("h1.filmtitle").First().Text()
 	if title == "" {
 		title = slug
 	}
 
+	releaseYear, err := strconv.Atoi(strings.TrimSpace(doc.Find(".releaseyear a, .releasedate a").First().Text()))
+	if err != nil {
+		releaseYear = 0
+	}
+
 	actors := []string{}
 	roles := make(map[string][]string)
 	doc.Find("a[href^='/actor/']").Each(func(i int, s *goquery.Selection) {
@@ -136,24 +198,44 @@ func fetchFilm(slug string) Film {
 		cast = append(cast, Credit{Actor: actor, Roles: strings.Join(roles[actor], " / ")})
 	}
 
-	film := Film{Slug: slug, Title: title, Cast: cast}
+	return Film{Slug: slug, Title: title, ReleaseYear: releaseYear, Cast: cast}, nil
+}
 
-	saveFilmToCache(film)
+func (a *analyzer) fetchFollowing(ctx context.Context, username string, limit int) ([]string, error) {
+	return a.fetchPeople(ctx, username, "following", limit)
+}
 
-	return film
+func (a *analyzer) fetchFollowers(ctx context.Context, username string, limit int) ([]string, error) {
+	return a.fetchPeople(ctx, username, "followers", limit)
 }
 
-func fetchFollowing(username string) []string {
-	url := fmt.Sprintf("https://letterboxd.com/%s/following/", username)
-	doc := fetchLetterboxdDoc(url)
+// fetchPeople pages through one of a user's people lists, stopping after limit people if limit > 0
+func (a *analyzer) fetchPeople(ctx context.Context, username string, list string, limit int) ([]string, error) {
+	people := []string{}
+	for page := 1; true; page++ {
+		url := fmt.Sprintf("https://letterboxd.com/%s/%s/", username, list)
+		if page > 1 {
+			url = fmt.Sprintf("https://letterboxd.com/%s/%s/page/%d/", username, list, page)
+		}
+		doc, err := a.fetchLetterboxdDoc(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		doc.Find("td.table-person h3 a").Each(func(i int, s *goquery.Selection) {
+			href, exists := s.Attr("href")
+			if exists {
+				people = append(people, strings.Trim(href, "/"))
+			}
+		})
 
-	following := []string{}
-	doc.Find("td.table-person h3 a").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if exists {
-			following = append(following, strings.Trim(href, "/"))
+		if limit > 0 && len(people) >= limit {
+			return people[:limit], nil
 		}
-	})
+		if doc.Find("a.next").Length() == 0 {
+			break
+		}
+	}
 
-	return following
+	return people, nil
 }
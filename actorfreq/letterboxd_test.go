@@ -1,6 +1,7 @@
 package actorfreq
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"reflect"
@@ -48,7 +49,10 @@ func TestFetchFilmSlugs(t *testing.T) {
 		}, nil
 	})
 
-	actualFilmSlugs := fetchFilmSlugs("testUser", "date")
+	actualFilmSlugs, err := defaultAnalyzer().fetchFilmSlugs(context.Background(), "testUser", "date", nil)
+	if err != nil {
+		t.Fatalf("Failed to fetch film slugs: %v", err)
+	}
 
 	expectedFilmSlugs := []string{
 		"saving-private-ryan", "forrest-gump", "toy-story",
@@ -105,8 +109,12 @@ func TestFetchFilm(t *testing.T) {
 	})
 
 	setUpInMemorySQLiteDB()
+	migrateDB()
 
-	actualFilm := fetchFilm("toy-story")
+	actualFilm, err := defaultAnalyzer().fetchFilm(context.Background(), "toy-story")
+	if err != nil {
+		t.Fatalf("Failed to fetch film: %v", err)
+	}
 
 	expectedFilm := Film{
 		Slug:  "toy-story",
@@ -154,8 +162,12 @@ func TestFetchFilm_NoValuesOnPage(t *testing.T) {
 	})
 
 	setUpInMemorySQLiteDB()
+	migrateDB()
 
-	actualFilm := fetchFilm("toy-story")
+	actualFilm, err := defaultAnalyzer().fetchFilm(context.Background(), "toy-story")
+	if err != nil {
+		t.Fatalf("Failed to fetch film: %v", err)
+	}
 
 	expectedFilm := Film{
 		Slug:  "toy-story",
@@ -173,3 +185,27 @@ func TestFetchFilm_NoValuesOnPage(t *testing.T) {
 		}
 	}
 }
+
+func TestFetchFilm_NotFound(t *testing.T) {
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     "404 Not Found",
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	_, err := defaultAnalyzer().fetchFilm(context.Background(), "missing")
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("Expected a 404 error, got %v", err)
+	}
+	if _, exists := defaultAnalyzer().fetchCachedFilm("missing"); exists {
+		t.Error("Expected a failed fetch not to be cached")
+	}
+}
@@ -0,0 +1,309 @@
+package actorfreq
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Metrics are written in the Prometheus text exposition format by hand, which is all /metrics
+// needs, rather than pulling in the Prometheus client library
+
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metric is a family of series written to /metrics
+type metric interface {
+	write(w io.Writer)
+}
+
+type labelledValues struct {
+	mutex  sync.Mutex
+	labels []string
+	series map[string][]string // label values by their joined key
+}
+
+func (lv *labelledValues) key(values []string) string {
+	if len(values) != len(lv.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(lv.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	if _, found := lv.series[key]; !found {
+		lv.series[key] = values
+	}
+	return key
+}
+
+func (lv *labelledValues) sortedKeys() []string {
+	keys := make([]string, 0, len(lv.series))
+	for key := range lv.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label pairs, with extra appended, as {name="value",...}
+func formatLabels(names []string, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelValueEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	values map[string]float64
+	labelledValues
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:           name,
+		help:           help,
+		values:         make(map[string]float64),
+		labelledValues: labelledValues{labels: labels, series: make(map[string][]string)},
+	}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[c.key(labelValues)]++
+}
+
+func (c *counterVec) add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[c.key(labelValues)] += value
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.series[key]), formatValue(c.values[key]))
+	}
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	counts  map[string][]uint64 // per bucket, not cumulative
+	sums    map[string]float64
+	totals  map[string]uint64
+	labelledValues
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:           name,
+		help:           help,
+		buckets:        buckets,
+		counts:         make(map[string][]uint64),
+		sums:           make(map[string]float64),
+		totals:         make(map[string]uint64),
+		labelledValues: labelledValues{labels: labels, series: make(map[string][]string)},
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := h.key(labelValues)
+	if _, found := h.counts[key]; !found {
+		h.counts[key] = make([]uint64, len(h.buckets))
+	}
+	if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+		h.counts[key][i]++
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range h.sortedKeys() {
+		values := h.series[key]
+		cumulative := uint64(0)
+		for i, bucket := range h.buckets {
+			cumulative += h.counts[key][i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatValue(bucket)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatValue(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), h.totals[key])
+	}
+}
+
+// funcMetric reads its series when scraped, for values that are already tracked elsewhere
+type funcMetric struct {
+	name   string
+	help   string
+	kind   string // counter or gauge
+	labels []string
+	read   func() map[string]float64 // by label value, or a single "" key when unlabelled
+}
+
+func (f funcMetric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	values := f.read()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		labels := ""
+		if len(f.labels) > 0 {
+			labels = formatLabels(f.labels, []string{key})
+		}
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatValue(values[key]))
+	}
+}
+
+func single(value float64) map[string]float64 {
+	return map[string]float64{"": value}
+}
+
+var (
+	letterboxdRequests = newCounterVec("actorfreq_letterboxd_requests_total",
+		"Requests made to Letterboxd.", "page_type", "status")
+	letterboxdRequestDuration = newHistogramVec("actorfreq_letterboxd_request_duration_seconds",
+		"Time taken by requests to Letterboxd.", durationBuckets, "page_type", "status")
+	filmCacheLookups = newCounterVec("actorfreq_film_cache_lookups_total",
+		"Films looked up in the film cache by analyses.", "result")
+	httpRequestDuration = newHistogramVec("actorfreq_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, including streamed responses.", durationBuckets, "handler", "method", "status")
+)
+
+// metrics are written by the server's /metrics: the series shared by the whole process, and
+// those read from the server's own caches and database. The request cache series are left out
+// when the server doesn't cache results.
+func (s *Server) metrics() []metric {
+	metrics := []metric{
+		letterboxdRequests,
+		letterboxdRequestDuration,
+		filmCacheLookups,
+	}
+	if s.requestCache != nil {
+		metrics = append(metrics, s.requestCacheMetrics()...)
+	}
+	return append(metrics,
+		funcMetric{"actorfreq_active_requests", "Analyses in progress, which pause precaching.", "gauge", nil,
+			func() map[string]float64 { return single(float64(atomic.LoadInt32(&activeRequests))) }},
+		funcMetric{"actorfreq_precache_queue_depth", "Tasks waiting in the precache queue.", "gauge", []string{"kind"},
+			func() map[string]float64 { return precacheQueueDepths(s.db) }},
+		httpRequestDuration,
+	)
+}
+
+func (s *Server) requestCacheMetrics() []metric {
+	return []metric{
+		funcMetric{"actorfreq_request_cache_hits_total", "Request cache lookups that found a fresh result.", "counter", nil,
+			func() map[string]float64 { return single(float64(atomic.LoadInt64(&s.requestCache.hits))) }},
+		funcMetric{"actorfreq_request_cache_misses_total", "Request cache lookups that found nothing or an expired result.", "counter", nil,
+			func() map[string]float64 { return single(float64(atomic.LoadInt64(&s.requestCache.misses))) }},
+		funcMetric{"actorfreq_request_cache_evictions_total", "Results removed from the request cache.", "counter", []string{"reason"},
+			func() map[string]float64 { return s.requestCache.evictionCounts() }},
+		funcMetric{"actorfreq_request_cache_bytes", "Estimated size of the results in the request cache.", "gauge", nil,
+			func() map[string]float64 { return single(float64(s.requestCache.size())) }},
+	}
+}
+
+// letterboxdPageType groups Letterboxd URLs so that metrics don't have a series per user or film
+func letterboxdPageType(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "other"
+	}
+	switch {
+	case strings.HasPrefix(u.Path, "/film/"):
+		return "film"
+	case strings.Contains(u.Path, "/films/"):
+		return "films_page"
+	case strings.Contains(u.Path, "/following/"):
+		return "following"
+	case strings.Contains(u.Path, "/followers/"):
+		return "followers"
+	default:
+		return "other"
+	}
+}
+
+func observeLetterboxdRequest(rawURL string, status string, duration time.Duration) {
+	pageType := letterboxdPageType(rawURL)
+	letterboxdRequests.inc(pageType, status)
+	letterboxdRequestDuration.observe(duration.Seconds(), pageType, status)
+}
+
+func precacheQueueDepths(db *gorm.DB) map[string]float64 {
+	depths := map[string]float64{}
+	for _, kind := range []string{precacheTaskCrawl, precacheTaskUser, precacheTaskFilm, precacheTaskRefresh} {
+		depths[kind] = 0
+	}
+	if db == nil {
+		return depths
+	}
+
+	var rows []struct {
+		Kind  string
+		Count int64
+	}
+	db.Model(&PrecacheTask{}).Select("kind, COUNT(*) AS count").Group("kind").Scan(&rows)
+	for _, row := range rows {
+		depths[row.Kind] = float64(row.Count)
+	}
+	return depths
+}
+
+// instrumentHandler records how long handler takes, labelled with the pattern it's registered with
+func instrumentHandler(pattern string, handler http.Handler) http.Handler {
+	// Drop the method so GET and POST patterns for a path share a handler label
+	_, path, found := strings.Cut(pattern, " ")
+	if !found {
+		path = pattern
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpRequestDuration.observe(time.Since(start).Seconds(), path, r.Method, strconv.Itoa(rec.status))
+	})
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range s.metrics() {
+		m.write(w)
+	}
+}
@@ -0,0 +1,141 @@
+package actorfreq
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metricValue finds a series in a /metrics body, returning 0 if it hasn't been written yet
+func metricValue(t *testing.T, body string, series string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if value, found := strings.CutPrefix(line, series+" "); found {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Invalid value for %s: %q", series, value)
+			}
+			return number
+		}
+	}
+	return 0
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		status := http.StatusOK
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/metricsUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" />` +
+				`<div data-film-slug="splash" />` +
+				`<div data-film-slug="missing" />`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1>` +
+				`<a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		case "https://letterboxd.com/film/missing/":
+			status = http.StatusNotFound
+		}
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+
+	requestCache := NewCache(1024 * 1024)
+	server := NewServer(WithRequestCache(requestCache))
+	server.precache.enqueuePrecacheFilms([]string{"queued-a", "queued-b"})
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+			t.Errorf("Expected Prometheus text format, got %q", contentType)
+		}
+		return rec.Body.String()
+	}
+
+	deltas := map[string]float64{
+		`actorfreq_letterboxd_requests_total{page_type="films_page",status="200"}`:                                                      2,
+		`actorfreq_letterboxd_requests_total{page_type="film",status="200"}`:                                                            1,
+		`actorfreq_letterboxd_requests_total{page_type="film",status="404"}`:                                                            1,
+		`actorfreq_letterboxd_request_duration_seconds_count{page_type="film",status="200"}`:                                            1,
+		`actorfreq_film_cache_lookups_total{result="hit"}`:                                                                              1,
+		`actorfreq_film_cache_lookups_total{result="miss"}`:                                                                             2,
+		`actorfreq_http_request_duration_seconds_count{handler="/api/v1/users/{username}/actors",method="GET",status="502"}`:            1,
+		`actorfreq_http_request_duration_seconds_bucket{handler="/api/v1/users/{username}/actors",method="GET",status="502",le="+Inf"}`: 1,
+	}
+	before := scrape()
+
+	// The missing film's 404 surfaces as an upstream error
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/metricsUser/actors", nil)
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+	requestCache.get("missing")
+
+	after := scrape()
+	for series, expected := range deltas {
+		if delta := metricValue(t, after, series) - metricValue(t, before, series); delta != expected {
+			t.Errorf("Expected %s to increase by %v, got %v", series, expected, delta)
+		}
+	}
+
+	absolute := map[string]float64{
+		`actorfreq_request_cache_misses_total`:                   2,
+		`actorfreq_request_cache_hits_total`:                     0,
+		`actorfreq_request_cache_evictions_total{reason="size"}`: 0,
+		`actorfreq_request_cache_bytes`:                          0,
+		`actorfreq_active_requests`:                              0,
+		`actorfreq_precache_queue_depth{kind="film"}`:            2,
+		`actorfreq_precache_queue_depth{kind="crawl"}`:           0,
+	}
+	for series, expected := range absolute {
+		if !strings.Contains(after, series+" ") {
+			t.Errorf("Expected %s to be written", series)
+		} else if value := metricValue(t, after, series); value != expected {
+			t.Errorf("Expected %s to be %v, got %v", series, expected, value)
+		}
+	}
+
+	for _, name := range []string{"actorfreq_letterboxd_request_duration_seconds", "actorfreq_http_request_duration_seconds"} {
+		if !strings.Contains(after, "# TYPE "+name+" histogram\n") {
+			t.Errorf("Expected %s to be a histogram", name)
+		}
+	}
+}
+
+func TestServerWithoutRequestCache(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "token")
+	server := NewServer(WithDB(nil), WithRequestCache(nil), WithFeatures(Features{Metrics: true, Admin: true}))
+
+	for _, c := range []struct{ method, target string }{
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/admin/request-cache"},
+		{http.MethodPost, "/admin/clear-request-cache"},
+	} {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s: expected 200, got %d: %s", c.method, c.target, rec.Code, rec.Body.String())
+		}
+		if c.target == "/metrics" && strings.Contains(rec.Body.String(), "actorfreq_request_cache_") {
+			t.Errorf("Expected no request cache series without a request cache, got %s", rec.Body.String())
+		}
+	}
+}
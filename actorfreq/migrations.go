@@ -0,0 +1,322 @@
+package actorfreq
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a migration applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migration is one ordered, reversible schema change. Each migration works on its own snapshot
+// of the models so that later changes to Film, Credit etc. don't change what it does.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+type filmV1 struct {
+	gorm.Model
+	Slug  string `gorm:"uniqueIndex"`
+	Title string
+	Cast  []creditV1 `gorm:"foreignKey:FilmID"`
+}
+
+func (filmV1) TableName() string { return "films" }
+
+type creditV1 struct {
+	gorm.Model
+	Actor  string
+	Roles  string
+	FilmID uint `gorm:"index"`
+}
+
+func (creditV1) TableName() string { return "credits" }
+
+type jobV2 struct {
+	ID        string `gorm:"primaryKey"`
+	Username  string
+	Params    string
+	Status    string `gorm:"index"`
+	Total     int
+	Progress  int
+	Result    string
+	Error     string
+	Seq       int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (jobV2) TableName() string { return "jobs" }
+
+type precacheTaskV3 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"uniqueIndex:idx_precache_task_kind_value"`
+	Value     string `gorm:"uniqueIndex:idx_precache_task_kind_value"`
+	Seed      string
+	Depth     int
+	Priority  int `gorm:"index"`
+	Attempts  int
+	CreatedAt time.Time
+}
+
+func (precacheTaskV3) TableName() string { return "precache_tasks" }
+
+type socialEdgeV4 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Seed      string `gorm:"uniqueIndex:idx_social_edge_seed_username"`
+	Username  string `gorm:"uniqueIndex:idx_social_edge_seed_username;index"`
+	CreatedAt time.Time
+}
+
+func (socialEdgeV4) TableName() string { return "social_edges" }
+
+type filmV5 struct {
+	gorm.Model
+	Slug        string `gorm:"uniqueIndex"`
+	Title       string
+	ReleaseYear int
+	FetchedAt   time.Time
+	ExpiresAt   *time.Time `gorm:"index"`
+}
+
+func (filmV5) TableName() string { return "films" }
+
+type shareV7 struct {
+	ID              string `gorm:"primaryKey"`
+	Username        string
+	Params          string
+	Result          string
+	DeleteTokenHash string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time `gorm:"index"`
+}
+
+func (shareV7) TableName() string { return "shares" }
+
+type precacheTaskV8 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"`
+	Value     string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"`
+	Seed      string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"`
+	Depth     int
+	Priority  int `gorm:"index"`
+	Attempts  int
+	NotBefore *time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (precacheTaskV8) TableName() string { return "precache_tasks" }
+
+// Tables created by AutoMigrate before migrations were introduced already match these, so
+// creating them again is a no-op that adopts them into the schema_migrations history
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_films_and_credits",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&filmV1{}, &creditV1{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&creditV1{}, &filmV1{})
+		},
+	},
+	{
+		version: 2,
+		name:    "create_jobs",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&jobV2{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&jobV2{})
+		},
+	},
+	{
+		version: 3,
+		name:    "create_precache_tasks",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&precacheTaskV3{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&precacheTaskV3{})
+		},
+	},
+	{
+		version: 4,
+		name:    "create_social_edges",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&socialEdgeV4{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&socialEdgeV4{})
+		},
+	},
+	{
+		version: 5,
+		name:    "add_film_expiry",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&filmV5{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&filmV5{}, "ExpiresAt"); err != nil {
+				return err
+			}
+			for _, column := range []string{"ReleaseYear", "FetchedAt", "ExpiresAt"} {
+				if err := tx.Migrator().DropColumn(&filmV5{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 6,
+		name:    "index_credits_by_film_and_actor",
+		up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_credits_film_actor ON credits (film_id, actor)").Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP INDEX IF EXISTS idx_credits_film_actor").Error
+		},
+	},
+	{
+		version: 7,
+		name:    "create_shares",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&shareV7{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&shareV7{})
+		},
+	},
+	{
+		version: 8,
+		name:    "add_precache_backoff_and_seeded_crawls",
+		up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&precacheTaskV3{}, "idx_precache_task_kind_value") {
+				if err := tx.Migrator().DropIndex(&precacheTaskV3{}, "idx_precache_task_kind_value"); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&precacheTaskV8{})
+		},
+		down: func(tx *gorm.DB) error {
+			// Crawls of the same user from different seeds collapse back into one
+			err := tx.Exec("DELETE FROM precache_tasks WHERE id NOT IN (SELECT MIN(id) FROM precache_tasks GROUP BY kind, value)").Error
+			if err != nil {
+				return err
+			}
+			for _, index := range []string{"idx_precache_task_kind_value_seed", "NotBefore"} {
+				if err := tx.Migrator().DropIndex(&precacheTaskV8{}, index); err != nil {
+					return err
+				}
+			}
+			if err := tx.Migrator().DropColumn(&precacheTaskV8{}, "NotBefore"); err != nil {
+				return err
+			}
+			return tx.AutoMigrate(&precacheTaskV3{})
+		},
+	},
+}
+
+func latestMigrationVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// appliedMigrations lists the applied migrations without changing the database, so a database
+// that has never been migrated has none
+func appliedMigrations(db *gorm.DB) ([]SchemaMigration, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	var applied []SchemaMigration
+	err := db.Order("version").Find(&applied).Error
+	return applied, err
+}
+
+// checkSchemaVersion refuses databases migrated by a newer build, whose schema we can't know
+func checkSchemaVersion(applied []SchemaMigration) error {
+	latest := latestMigrationVersion()
+	for _, m := range applied {
+		if m.Version > latest {
+			return fmt.Errorf("database schema version %d (%s) is newer than the latest known migration %d; upgrade actorfreq or migrate the database down with the newer build",
+				m.Version, m.Name, latest)
+		}
+	}
+	return nil
+}
+
+// migrateTo applies or reverts migrations, each in its own transaction, until the database is at target
+func migrateTo(db *gorm.DB, target int) error {
+	if target < 0 || target > latestMigrationVersion() {
+		return fmt.Errorf("unknown migration version %d, expected 0 to %d", target, latestMigrationVersion())
+	}
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(applied); err != nil {
+		return err
+	}
+	isApplied := func(version int) bool {
+		return slices.ContainsFunc(applied, func(m SchemaMigration) bool { return m.Version == version })
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version <= target || !isApplied(m.version) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: m.version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("Reverted migration", "version", m.version, "name", m.name)
+	}
+
+	for _, m := range migrations {
+		if m.version > target || isApplied(m.version) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("Applied migration", "version", m.version, "name", m.name)
+	}
+
+	return nil
+}
+
+// currentMigrationVersion is the highest applied migration, or 0 for an empty database
+func currentMigrationVersion(db *gorm.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].Version, nil
+}
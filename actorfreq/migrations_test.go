@@ -0,0 +1,104 @@
+package actorfreq
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	if err := migrateDB(); err != nil {
+		t.Fatalf("Expected migrations to apply, got %v", err)
+	}
+	if version, _ := currentMigrationVersion(cacheDB); version != latestMigrationVersion() {
+		t.Errorf("Expected version %d, got %d", latestMigrationVersion(), version)
+	}
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+
+	if err := migrateTo(cacheDB, 3); err != nil {
+		t.Fatalf("Expected migrations to revert, got %v", err)
+	}
+	if cacheDB.Migrator().HasTable("social_edges") || cacheDB.Migrator().HasColumn("films", "expires_at") {
+		t.Errorf("Expected migrations 4 and 5 to be reverted")
+	}
+	if film, found := defaultAnalyzer().fetchCachedFilm("big"); !found || len(film.Cast) != 1 {
+		t.Errorf("Expected cached films to survive reverting, got %+v", film)
+	}
+
+	if err := migrateTo(cacheDB, latestMigrationVersion()); err != nil {
+		t.Fatalf("Expected migrations to reapply, got %v", err)
+	}
+	if !cacheDB.Migrator().HasTable("social_edges") || !cacheDB.Migrator().HasColumn("films", "expires_at") {
+		t.Errorf("Expected migrations 4 and 5 to be reapplied")
+	}
+}
+
+func TestMigrateAdoptsAutoMigratedSchema(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	cacheDB.AutoMigrate(&Film{}, &Credit{}, &Job{}, &PrecacheTask{}, &SocialEdge{})
+	cacheDB.Create(&Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+
+	if err := migrateDB(); err != nil {
+		t.Fatalf("Expected migrations to adopt the existing schema, got %v", err)
+	}
+	if film, found := defaultAnalyzer().fetchCachedFilm("big"); !found || len(film.Cast) != 1 {
+		t.Errorf("Expected cached films to be kept, got %+v", film)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	cacheDB.Create(&SchemaMigration{Version: latestMigrationVersion() + 1, Name: "from_the_future"})
+
+	err := migrateDB()
+	if err == nil || !strings.Contains(err.Error(), "from_the_future") {
+		t.Errorf("Expected newer schema to be refused, got %v", err)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	t.Setenv("DISABLE_POSTGRES_DB", "true")
+	t.Setenv("DISABLE_IN_MEMORY_SQLITE_DB", "false")
+
+	var out bytes.Buffer
+	if err := migrateCommand(&out, configFromEnv(), []string{"to", "2"}); err != nil {
+		t.Fatalf("Expected migrate to 2 to succeed, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(migrations) {
+		t.Fatalf("Expected a status line per migration, got %q", out.String())
+	}
+	for i, line := range lines {
+		applied := strings.Contains(line, "applied")
+		if applied != (i < 2) {
+			t.Errorf("Unexpected status for migration %d: %q", i+1, line)
+		}
+	}
+
+	if err := migrateCommand(&out, configFromEnv(), []string{"to", "99"}); err == nil {
+		t.Errorf("Expected unknown version to be refused")
+	}
+	if err := migrateCommand(&out, configFromEnv(), []string{"sideways"}); err == nil {
+		t.Errorf("Expected unknown command to be refused")
+	}
+}
+
+func TestMigrationStatusLeavesDatabaseUnchanged(t *testing.T) {
+	setUpInMemorySQLiteDB()
+
+	if version, err := currentMigrationVersion(cacheDB); err != nil || version != 0 {
+		t.Errorf("Expected version 0 for an unmigrated database, got %d, %v", version, err)
+	}
+	var out bytes.Buffer
+	if err := printMigrationStatus(&out); err != nil {
+		t.Fatalf("Expected status to succeed, got %v", err)
+	}
+	if strings.Contains(out.String(), "applied") {
+		t.Errorf("Expected every migration to be pending, got %q", out.String())
+	}
+	if cacheDB.Migrator().HasTable(&SchemaMigration{}) {
+		t.Errorf("Expected status not to create the schema_migrations table")
+	}
+}
@@ -0,0 +1,611 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sortStrategyValues lists the Letterboxd sort orders offered by the sortStrategy select in index.html
+var sortStrategyValues = []string{
+	"name", "popular",
+	"date", "date-earliest",
+	"rated-date", "rated-date-earliest",
+	"release", "release-earliest",
+	"rating", "rating-lowest",
+	"entry-rating", "entry-rating-lowest",
+	"shortest", "longest",
+}
+
+// roleFilterValues lists the roleFilter checkboxes in index.html, as understood by filterRoles
+var roleFilterValues = []string{"additional_voices", "voice", "uncredited"}
+
+// requestParameter describes a query parameter read by getRequestConfig
+type requestParameter struct {
+	Name        string
+	Type        string // "string" or "integer"
+	Description string
+	Enum        []string
+	Repeated    bool
+}
+
+var requestParameters = []requestParameter{
+	{
+		Name:        "sortStrategy",
+		Type:        "string",
+		Description: "Order of the user's films before topNMovies is applied, defaults to date",
+		Enum:        sortStrategyValues,
+	},
+	{
+		Name:        "topNMovies",
+		Type:        "integer",
+		Description: "Number of films to consider, all films by default",
+	},
+	{
+		Name:        "roleFilter",
+		Type:        "string",
+		Description: "Kinds of roles to filter out",
+		Enum:        roleFilterValues,
+		Repeated:    true,
+	},
+}
+
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Field, e.Message)
+}
+
+// validateRequestForm checks the form against requestParameters before it reaches getRequestConfig
+func validateRequestForm(form url.Values) *fieldError {
+	for _, parameter := range requestParameters {
+		values := form[parameter.Name]
+		if !parameter.Repeated && len(values) > 1 {
+			return &fieldError{Field: parameter.Name, Message: "must not be repeated"}
+		}
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if parameter.Type == "integer" {
+				if _, err := strconv.Atoi(value); err != nil {
+					return &fieldError{Field: parameter.Name, Message: fmt.Sprintf("%q is not an integer", value)}
+				}
+			}
+			if parameter.Enum != nil && !slices.Contains(parameter.Enum, value) {
+				return &fieldError{
+					Field:   parameter.Name,
+					Message: fmt.Sprintf("%q is not one of %s", value, strings.Join(parameter.Enum, ", ")),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writeFieldError(w http.ResponseWriter, r *http.Request, err *fieldError) {
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusBadRequest, apiErrorResponse{
+			Error: apiError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_parameter",
+				Message: err.Error(),
+				Field:   err.Field,
+			},
+		})
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(s.openAPISpec())
+}
+
+// openAPISpec builds the OpenAPI 3 document for the server's routes from the request parameters
+// and response types
+func (s *Server) openAPISpec() map[string]any {
+	schemas := map[string]any{}
+
+	queryParameters := []any{}
+	for _, parameter := range requestParameters {
+		queryParameters = append(queryParameters, openAPIParameter(parameter))
+	}
+	usernameQueryParameter := map[string]any{
+		"name": "username", "in": "query", "required": true,
+		"description": "Letterboxd username", "schema": map[string]any{"type": "string"},
+	}
+	usernamePathParameter := map[string]any{
+		"name": "username", "in": "path", "required": true,
+		"description": "Letterboxd username", "schema": map[string]any{"type": "string"},
+	}
+	shareIDPathParameter := map[string]any{
+		"name": "id", "in": "path", "required": true,
+		"description": "Share ID", "schema": map[string]any{"type": "string"},
+	}
+	jobIDPathParameter := map[string]any{
+		"name": "id", "in": "path", "required": true,
+		"description": "Job ID", "schema": map[string]any{"type": "string"},
+	}
+
+	cardParameters := []any{
+		map[string]any{
+			"name": "size", "in": "query", "description": "The card's size, landscape (1200x630) by default",
+			"schema": map[string]any{"type": "string", "enum": cardSizeValues},
+		},
+		map[string]any{
+			"name": "actors", "in": "query", "description": fmt.Sprintf("Number of actors to show, %d by default", defaultCardActors),
+			"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": maxCardActors},
+		},
+	}
+	pngResponse := map[string]any{
+		"description": "The card",
+		"content":     map[string]any{"image/png": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}},
+	}
+
+	errorResponse := func(description string) map[string]any {
+		return jsonResponse(description, jsonSchema(reflect.TypeOf(apiErrorResponse{}), schemas))
+	}
+
+	actorsEvent := map[string]any{
+		"description": "Named events with increasing IDs: total, progress, film, warning and leaderboard " +
+			"while fetching, then result or error, and finally done",
+		"oneOf": []any{
+			jsonSchema(reflect.TypeOf(sseTotalData{}), schemas),
+			jsonSchema(reflect.TypeOf(sseProgressData{}), schemas),
+			jsonSchema(reflect.TypeOf(ResolvedFilm{}), schemas),
+			jsonSchema(reflect.TypeOf(sseMessageData{}), schemas),
+			jsonSchema(reflect.TypeOf(sseLeaderboardData{}), schemas),
+			jsonSchema(reflect.TypeOf(sseResultData{}), schemas),
+		},
+	}
+	jobEvent := jsonSchema(reflect.TypeOf(jobResponse{}), schemas)
+
+	paths := map[string]any{
+		"/" + FetchActorsPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Stream actor appearance counts, or return them as JSON when requested via Accept",
+				"operationId": "fetchActors",
+				"parameters":  append([]any{usernameQueryParameter}, queryParameters...),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Progress events followed by the actors, or the actors as JSON",
+						"content": map[string]any{
+							"text/event-stream": map[string]any{"schema": actorsEvent},
+							"application/json": map[string]any{
+								"schema": jsonSchema(reflect.TypeOf(Result{}), schemas),
+							},
+						},
+					},
+					"400": errorResponse("Missing username or invalid parameter"),
+					"502": errorResponse("Letterboxd could not be scraped"),
+				},
+			},
+		},
+		"/api/" + apiVersion + "/users/{username}/actors": map[string]any{
+			"get": map[string]any{
+				"summary":     "Get actor appearance counts for a user",
+				"operationId": "getUserActors",
+				"parameters":  append([]any{usernamePathParameter}, queryParameters...),
+				"responses": map[string]any{
+					"200": jsonResponse("Actors appearing in more than one of the user's films",
+						jsonSchema(reflect.TypeOf(Result{}), schemas)),
+					"400": errorResponse("Invalid parameter"),
+					"502": errorResponse("Letterboxd could not be scraped"),
+				},
+			},
+		},
+		"/api/" + apiVersion + "/users/{username}/export/{format}": map[string]any{
+			"get": map[string]any{
+				"summary": "Download a user's actors as CSV with a row per role, indented JSON or a Markdown " +
+					"table, or one actor's films as a CSV for Letterboxd's list importer",
+				"operationId": "exportUserActors",
+				"parameters": append([]any{
+					usernamePathParameter,
+					map[string]any{
+						"name": "format", "in": "path", "required": true,
+						"schema": map[string]any{"type": "string", "enum": exportFormatValues},
+					},
+					map[string]any{
+						"name": "actor", "in": "query",
+						"description": "The actor whose films to list, required for the letterboxd format",
+						"schema":      map[string]any{"type": "string"},
+					},
+				}, queryParameters...),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "The export, as an attachment",
+						"content": map[string]any{
+							"text/csv":         map[string]any{"schema": map[string]any{"type": "string"}},
+							"text/markdown":    map[string]any{"schema": map[string]any{"type": "string"}},
+							"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(Result{}), schemas)},
+						},
+					},
+					"400": errorResponse("Unknown format, missing actor or invalid parameter"),
+					"404": errorResponse("The actor isn't in more than one of the user's films"),
+					"502": errorResponse("Letterboxd could not be scraped"),
+				},
+			},
+		},
+		"/api/" + apiVersion + "/users/{username}/card.png": map[string]any{
+			"get": map[string]any{
+				"summary":     "Draw a user's top actors as a PNG card for posting to social media",
+				"operationId": "getUserCard",
+				"parameters":  append([]any{usernamePathParameter}, append(cardParameters, queryParameters...)...),
+				"responses": map[string]any{
+					"200": pngResponse,
+					"400": errorResponse("Invalid parameter"),
+					"502": errorResponse("Letterboxd could not be scraped"),
+				},
+			},
+		},
+		"/shares": map[string]any{
+			"post": map[string]any{
+				"summary":     "Save a user's actors under a short ID, to be viewed at /s/{id} until they expire",
+				"operationId": "createShare",
+				"parameters": append([]any{
+					usernameQueryParameter,
+					map[string]any{
+						"name": "expiresInDays", "in": "query",
+						"description": "Days to keep the share for, less than the default if given",
+						"schema":      map[string]any{"type": "integer", "minimum": 1},
+					},
+				}, queryParameters...),
+				"responses": map[string]any{
+					"201": jsonResponse("The share, with the token to delete it", jsonSchema(reflect.TypeOf(shareResponse{}), schemas)),
+					"400": errorResponse("Missing username or invalid parameter"),
+					"502": errorResponse("Letterboxd could not be scraped"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/shares/{id}": map[string]any{
+			"delete": map[string]any{
+				"summary":     "Delete a share with its delete token as a bearer token",
+				"operationId": "deleteShare",
+				"parameters":  []any{shareIDPathParameter},
+				"security":    []any{map[string]any{"shareDeleteToken": []any{}}},
+				"responses": map[string]any{
+					"204": map[string]any{"description": "The share was deleted"},
+					"403": errorResponse("Wrong delete token"),
+					"404": errorResponse("Share not found or expired"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/s/{id}": map[string]any{
+			"get": map[string]any{
+				"summary":     "View a share as a page with Open Graph tags",
+				"operationId": "viewShare",
+				"parameters":  []any{shareIDPathParameter},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "The shared result",
+						"content":     map[string]any{"text/html": map[string]any{"schema": map[string]any{"type": "string"}}},
+					},
+					"404": plainTextResponse("Share not found or expired"),
+					"503": plainTextResponse("No database is configured"),
+				},
+			},
+		},
+		"/s/{id}/card.png": map[string]any{
+			"get": map[string]any{
+				"summary":     "Draw a share as a PNG card, the Open Graph image of its page",
+				"operationId": "getShareCard",
+				"parameters":  append([]any{shareIDPathParameter}, cardParameters...),
+				"responses": map[string]any{
+					"200": pngResponse,
+					"400": errorResponse("Invalid parameter"),
+					"404": errorResponse("Share not found or expired"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/jobs": map[string]any{
+			"post": map[string]any{
+				"summary":     "Start a background analysis job",
+				"operationId": "createJob",
+				"parameters":  append([]any{usernameQueryParameter}, queryParameters...),
+				"responses": map[string]any{
+					"202": jsonResponse("The queued job", jobEvent),
+					"400": errorResponse("Missing username or invalid parameter"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/jobs/{id}": map[string]any{
+			"get": map[string]any{
+				"summary":     "Get the status of a job",
+				"operationId": "getJob",
+				"parameters":  []any{jobIDPathParameter},
+				"responses": map[string]any{
+					"200": jsonResponse("The job", jobEvent),
+					"404": errorResponse("Job not found"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/jobs/{id}/events": map[string]any{
+			"get": map[string]any{
+				"summary":     "Stream job snapshots, resuming after the Last-Event-ID header",
+				"operationId": "streamJobEvents",
+				"parameters": []any{
+					jobIDPathParameter,
+					map[string]any{
+						"name": "Last-Event-ID", "in": "header",
+						"schema": map[string]any{"type": "integer"},
+					},
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Job snapshots as progress events, then a result or error event when the job is done or failed, then a done event",
+						"content": map[string]any{
+							"text/event-stream": map[string]any{"schema": jobEvent},
+						},
+					},
+					"404": errorResponse("Job not found"),
+					"503": errorResponse("No database is configured"),
+				},
+			},
+		},
+		"/admin/clear-request-cache": map[string]any{
+			"post": adminOperation(clearRequestCacheOperation("clearRequestCache"), schemas),
+		},
+		"/clear-request-cache": map[string]any{
+			"post": adminOperation(clearRequestCacheOperation("clearRequestCacheLegacy"), schemas),
+		},
+		"/admin/cache-stats": map[string]any{
+			"get": adminOperation(map[string]any{
+				"summary":     "Get film cache counts, fetch times and the most cached actors",
+				"operationId": "getCacheStats",
+				"parameters":  []any{limitParameter("Number of top actors to list")},
+				"responses": map[string]any{
+					"200": jsonResponse("Film cache statistics", jsonSchema(reflect.TypeOf(filmCacheStats{}), schemas)),
+					"400": jsonResponse("Invalid limit", jsonSchema(reflect.TypeOf(apiErrorResponse{}), schemas)),
+				},
+			}, schemas),
+		},
+		"/admin/empty-cast-films": map[string]any{
+			"get": adminOperation(map[string]any{
+				"summary":     "List cached films without a cast",
+				"operationId": "listEmptyCastFilms",
+				"parameters":  []any{limitParameter("Number of films to list")},
+				"responses": map[string]any{
+					"200": jsonResponse("Films without a cast, most recently fetched first", jsonSchema(reflect.TypeOf([]cachedFilmSummary{}), schemas)),
+					"400": jsonResponse("Invalid limit", jsonSchema(reflect.TypeOf(apiErrorResponse{}), schemas)),
+				},
+			}, schemas),
+		},
+		"/admin/request-cache": map[string]any{
+			"get": adminOperation(map[string]any{
+				"summary":     "Get request cache size, hit rate and entries",
+				"operationId": "getRequestCacheStats",
+				"responses": map[string]any{
+					"200": jsonResponse("Request cache statistics", jsonSchema(reflect.TypeOf(requestCacheStats{}), schemas)),
+				},
+			}, schemas),
+		},
+		"/admin/precache": map[string]any{
+			"get": adminOperation(map[string]any{
+				"summary":     "Get the precache queue depth and throughput",
+				"operationId": "getPrecacheStatus",
+				"responses": map[string]any{
+					"200": jsonResponse("Precache status", jsonSchema(reflect.TypeOf(precacheStatus{}), schemas)),
+				},
+			}, schemas),
+		},
+		"/healthz": map[string]any{
+			"get": map[string]any{
+				"summary":     "Check that the process is alive",
+				"operationId": "getHealth",
+				"responses": map[string]any{
+					"200": jsonResponse("The process is alive", jsonSchema(reflect.TypeOf(healthStatus{}), schemas)),
+				},
+			},
+		},
+		"/readyz": map[string]any{
+			"get": map[string]any{
+				"summary":     "Check that the database is reachable and migrated, and the server isn't shutting down",
+				"operationId": "getReadiness",
+				"responses": map[string]any{
+					"200": jsonResponse("Ready to serve requests", jsonSchema(reflect.TypeOf(healthStatus{}), schemas)),
+					"503": jsonResponse("Not ready, with the result of each check", jsonSchema(reflect.TypeOf(healthStatus{}), schemas)),
+				},
+			},
+		},
+		"/metrics": map[string]any{
+			"get": map[string]any{
+				"summary":     "Get metrics for the scraper, caches and HTTP handlers",
+				"operationId": "getMetrics",
+				"responses": map[string]any{
+					"200": plainTextResponse("Metrics in the Prometheus text exposition format"),
+				},
+			},
+		},
+		"/openapi.json": map[string]any{
+			"get": map[string]any{
+				"summary":     "This document",
+				"operationId": "getOpenAPI",
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "OpenAPI 3 document",
+						"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+					},
+				},
+			},
+		},
+	}
+	if !s.features.Metrics {
+		delete(paths, "/metrics")
+	}
+	if !s.features.Admin {
+		for path := range paths {
+			if strings.HasPrefix(path, "/admin/") || path == "/clear-request-cache" {
+				delete(paths, path)
+			}
+		}
+	}
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "actorfreq",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"adminToken":       map[string]any{"type": "http", "scheme": "bearer"},
+				"adminBasic":       map[string]any{"type": "http", "scheme": "basic"},
+				"shareDeleteToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	if s.basePath != "/" {
+		spec["servers"] = []any{map[string]any{"url": strings.TrimSuffix(s.basePath, "/")}}
+	}
+	return spec
+}
+
+// adminOperation documents the credentials that requireAdmin asks for
+func adminOperation(operation map[string]any, schemas map[string]any) map[string]any {
+	operation["security"] = []any{
+		map[string]any{"adminToken": []any{}},
+		map[string]any{"adminBasic": []any{}},
+	}
+	errorResponse := jsonSchema(reflect.TypeOf(apiErrorResponse{}), schemas)
+	responses := operation["responses"].(map[string]any)
+	responses["401"] = jsonResponse("Missing or invalid admin credentials", errorResponse)
+	responses["403"] = jsonResponse("No admin credentials are configured", errorResponse)
+	return operation
+}
+
+func clearRequestCacheOperation(operationID string) map[string]any {
+	return map[string]any{
+		"summary":     "Clear the request cache",
+		"operationId": operationID,
+		"responses": map[string]any{
+			"200": plainTextResponse("Request cache cleared"),
+		},
+	}
+}
+
+func limitParameter(description string) map[string]any {
+	return map[string]any{
+		"name":        "limit",
+		"in":          "query",
+		"description": description,
+		"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxAdminLimit, "default": defaultAdminLimit},
+	}
+}
+
+func openAPIParameter(parameter requestParameter) map[string]any {
+	schema := map[string]any{"type": parameter.Type}
+	if parameter.Enum != nil {
+		schema["enum"] = parameter.Enum
+	}
+	openAPIParameter := map[string]any{
+		"name":        parameter.Name,
+		"in":          "query",
+		"description": parameter.Description,
+		"schema":      schema,
+	}
+	if parameter.Repeated {
+		openAPIParameter["schema"] = map[string]any{"type": "array", "items": schema}
+		openAPIParameter["explode"] = true
+	}
+	return openAPIParameter
+}
+
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+func plainTextResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+		},
+	}
+}
+
+// jsonSchema describes how encoding/json marshals t, adding named structs to schemas
+func jsonSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]any{}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, found := schemas[t.Name()]; found {
+			return ref
+		}
+		schema := map[string]any{"type": "object"}
+		schemas[t.Name()] = schema // registered before recursing so self-references terminate
+
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type, schemas)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,221 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadOpenAPISpec round-trips the spec through JSON so it looks the way clients see it
+func loadOpenAPISpec(t *testing.T) map[string]any {
+	md, err := json.Marshal(NewServer().openAPISpec())
+	if err != nil {
+		t.Fatalf("Failed to marshal spec: %v", err)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(md, &spec); err != nil {
+		t.Fatalf("Failed to unmarshal spec: %v", err)
+	}
+	return spec
+}
+
+// validateSchema reports where value does not match schema, resolving $refs against the spec
+func validateSchema(spec map[string]any, schema map[string]any, value any, path string) []string {
+	if ref, found := schema["$ref"].(string); found {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, _ := spec["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if resolved == nil {
+			return []string{fmt.Sprintf("%s: unresolved $ref %q", path, ref)}
+		}
+		return validateSchema(spec, resolved, value, path)
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", path, value)}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		if required, found := schema["required"].([]any); found {
+			for _, name := range required {
+				if _, found := object[name.(string)]; !found {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+		for name, propertyValue := range object {
+			propertySchema, found := properties[name].(map[string]any)
+			if !found {
+				errs = append(errs, fmt.Sprintf("%s: undocumented property %q", path, name))
+				continue
+			}
+			errs = append(errs, validateSchema(spec, propertySchema, propertyValue, path+"."+name)...)
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", path, value)}
+		}
+		for i, item := range array {
+			errs = append(errs, validateSchema(spec, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected string, got %T", path, value))
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			errs = append(errs, fmt.Sprintf("%s: expected integer, got %v", path, value))
+		}
+	}
+	return errs
+}
+
+func TestOpenAPISpecMatchesHandlers(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+	t.Setenv("ADMIN_TOKEN", "spec-token")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/specUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" />` +
+				`<div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1>` +
+				`<a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1>` +
+				`<a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		default:
+			responseString = ""
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	server := NewServer()
+	spec := loadOpenAPISpec(t)
+
+	// Every documented operation must be routed to a handler other than the home page
+	for path, item := range spec["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			concretePath := strings.NewReplacer("{username}", "someone", "{id}", "someid", "{format}", "csv").Replace(path)
+			req := httptest.NewRequest(strings.ToUpper(method), concretePath, nil)
+			if _, pattern := server.mux.Handler(req); pattern == "/" || pattern == "" {
+				t.Errorf("Documented operation %s %s is not routed, got pattern %q", method, path, pattern)
+			}
+		}
+	}
+
+	cases := []struct {
+		method         string
+		path           string
+		target         string
+		expectedStatus int
+	}{
+		{http.MethodGet, "/api/v1/users/{username}/actors", "/api/v1/users/specUser/actors", http.StatusOK},
+		{http.MethodGet, "/api/v1/users/{username}/actors", "/api/v1/users/specUser/actors?sortStrategy=bogus", http.StatusBadRequest},
+		{http.MethodGet, "/" + FetchActorsPath, "/" + FetchActorsPath + "?username=specUser", http.StatusOK},
+		{http.MethodGet, "/" + FetchActorsPath, "/" + FetchActorsPath + "?username=specUser&topNMovies=ten", http.StatusBadRequest},
+		{http.MethodPost, "/jobs", "/jobs?roleFilter=voice", http.StatusBadRequest},
+		{http.MethodPost, "/jobs", "/jobs?username=specUser&roleFilter=cameo", http.StatusBadRequest},
+		{http.MethodGet, "/admin/precache", "/admin/precache", http.StatusOK},
+		{http.MethodGet, "/admin/cache-stats", "/admin/cache-stats", http.StatusOK},
+		{http.MethodGet, "/admin/cache-stats", "/admin/cache-stats?limit=-1", http.StatusBadRequest},
+		{http.MethodGet, "/admin/empty-cast-films", "/admin/empty-cast-films", http.StatusOK},
+		{http.MethodGet, "/admin/request-cache", "/admin/request-cache", http.StatusOK},
+		{http.MethodPost, "/admin/clear-request-cache", "/admin/clear-request-cache?token=missing", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		req.Header.Set("Accept", "application/json")
+		if !strings.Contains(c.target, "token=missing") {
+			req.Header.Set("Authorization", "Bearer spec-token")
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedStatus {
+			t.Errorf("%s %s: expected status %d, got %d: %s", c.method, c.target, c.expectedStatus, rec.Code, rec.Body.String())
+			continue
+		}
+
+		operation := spec["paths"].(map[string]any)[c.path].(map[string]any)[strings.ToLower(c.method)].(map[string]any)
+		response, found := operation["responses"].(map[string]any)[fmt.Sprint(c.expectedStatus)].(map[string]any)
+		if !found {
+			t.Errorf("%s %s: status %d is not documented", c.method, c.path, c.expectedStatus)
+			continue
+		}
+		schema := response["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+
+		var body any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: failed to unmarshal %q: %v", c.method, c.target, rec.Body.String(), err)
+			continue
+		}
+		for _, err := range validateSchema(spec, schema, body, "body") {
+			t.Errorf("%s %s: %s", c.method, c.target, err)
+		}
+	}
+}
+
+func TestValidateRequestFormNamesField(t *testing.T) {
+	server := NewServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/fetch-actors/?username=someone&sortStrategy=bogus", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	var apiErr apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("Failed to unmarshal error %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusBadRequest || apiErr.Error.Field != "sortStrategy" {
+		t.Errorf("Expected 400 naming sortStrategy, got %d %v", rec.Code, apiErr)
+	}
+}
+
+func TestRequestParameterEnumsMatchIndexHTML(t *testing.T) {
+	index, err := templates.ReadFile("templates/index.html")
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+
+	selectStart := strings.Index(string(index), `<select id="sortStrategy">`)
+	selectEnd := strings.Index(string(index), `</select>`)
+	var uiSortStrategies []string
+	for _, match := range regexp.MustCompile(`<option value="([^"]*)"`).FindAllStringSubmatch(string(index)[selectStart:selectEnd], -1) {
+		uiSortStrategies = append(uiSortStrategies, match[1])
+	}
+
+	var uiRoleFilters []string
+	for _, match := range regexp.MustCompile(`name="roleFilter"\s+value="([^"]*)"`).FindAllStringSubmatch(string(index), -1) {
+		uiRoleFilters = append(uiRoleFilters, match[1])
+	}
+
+	if !reflect.DeepEqual(sortStrategyValues, uiSortStrategies) {
+		t.Errorf("Expected sortStrategy options %v, got %v", sortStrategyValues, uiSortStrategies)
+	}
+	if !slices.Equal(roleFilterValues, uiRoleFilters) {
+		t.Errorf("Expected roleFilter checkboxes %v, got %v", roleFilterValues, uiRoleFilters)
+	}
+}
@@ -0,0 +1,153 @@
+package actorfreq
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats for the analyze and compare commands
+var outputFormats = []string{"table", "json", "csv", "markdown"}
+
+// writeResult writes the result's top limit actors, or all of them if limit is 0, in format. CSV
+// has a row for each of an actor's roles in each film.
+func writeResult(w io.Writer, format string, result *Result, limit int) error {
+	actors := result.Actors
+	if limit > 0 && limit < len(actors) {
+		actors = actors[:limit]
+	}
+
+	switch format {
+	case "json":
+		return writeIndentedJSON(w, Result{Username: result.Username, Actors: actors})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"actor", "count", "film_slug", "title", "role"})
+		for _, actor := range actors {
+			for _, movie := range actor.Movies {
+				for _, role := range strings.Split(movie.Roles, " / ") {
+					cw.Write([]string{actor.Name, strconv.Itoa(actor.Count), movie.FilmSlug, movie.Title, role})
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "markdown":
+		fmt.Fprintf(w, "| # | Actor | Films | Titles |\n|---:|---|---:|---|\n")
+		for i, actor := range actors {
+			var titles []string
+			for _, movie := range actor.Movies {
+				titles = append(titles, fmt.Sprintf("[%s](%s)", markdownEscape(movie.Title), letterboxdFilmURL(movie.FilmSlug)))
+			}
+			fmt.Fprintf(w, "| %d | %s | %d | %s |\n", i+1, markdownEscape(actor.Name), actor.Count, strings.Join(titles, ", "))
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "#\tACTOR\tFILMS\tTITLES")
+		for i, actor := range actors {
+			var titles []string
+			for _, movie := range actor.Movies {
+				titles = append(titles, movie.Title)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", i+1, actor.Name, actor.Count, strings.Join(titles, ", "))
+		}
+		return tw.Flush()
+	}
+}
+
+// writeLetterboxdList writes the actor's films as a CSV that Letterboxd's list importer accepts,
+// matching films by their URIs rather than their titles
+func writeLetterboxdList(w io.Writer, actor Actor) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Position", "Title", "LetterboxdURI"})
+	for i, movie := range actor.Movies {
+		cw.Write([]string{strconv.Itoa(i + 1), movie.Title, letterboxdFilmURL(movie.FilmSlug)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func letterboxdFilmURL(filmSlug string) string {
+	return fmt.Sprintf("https://letterboxd.com/film/%s/", filmSlug)
+}
+
+// comparison is the actors appearing in more than one film for each of two users
+type comparison struct {
+	Usernames [2]string       `json:"usernames"`
+	Actors    []comparedActor `json:"actors"`
+}
+
+type comparedActor struct {
+	Name   string `json:"name"`
+	Counts [2]int `json:"counts"` // films per user, in the order of Usernames
+}
+
+// compareResults finds the actors in both results, most films between the two users first
+func compareResults(a *Result, b *Result) comparison {
+	counts := make(map[string]int)
+	for _, actor := range b.Actors {
+		counts[actor.Name] = actor.Count
+	}
+
+	c := comparison{Usernames: [2]string{a.Username, b.Username}, Actors: []comparedActor{}}
+	for _, actor := range a.Actors {
+		if count, found := counts[actor.Name]; found {
+			c.Actors = append(c.Actors, comparedActor{Name: actor.Name, Counts: [2]int{actor.Count, count}})
+		}
+	}
+	// A stable sort keeps the first user's ranking for ties
+	sort.SliceStable(c.Actors, func(i, j int) bool {
+		return c.Actors[i].Counts[0]+c.Actors[i].Counts[1] > c.Actors[j].Counts[0]+c.Actors[j].Counts[1]
+	})
+	return c
+}
+
+func writeComparison(w io.Writer, format string, c comparison, limit int) error {
+	actors := c.Actors
+	if limit > 0 && limit < len(actors) {
+		actors = actors[:limit]
+	}
+
+	switch format {
+	case "json":
+		return writeIndentedJSON(w, comparison{Usernames: c.Usernames, Actors: actors})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"actor", c.Usernames[0], c.Usernames[1]})
+		for _, actor := range actors {
+			cw.Write([]string{actor.Name, strconv.Itoa(actor.Counts[0]), strconv.Itoa(actor.Counts[1])})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "markdown":
+		fmt.Fprintf(w, "| # | Actor | %s | %s |\n|---:|---|---:|---:|\n", markdownEscape(c.Usernames[0]), markdownEscape(c.Usernames[1]))
+		for i, actor := range actors {
+			fmt.Fprintf(w, "| %d | %s | %d | %d |\n", i+1, markdownEscape(actor.Name), actor.Counts[0], actor.Counts[1])
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "#\tACTOR\t%s\t%s\n", strings.ToUpper(c.Usernames[0]), strings.ToUpper(c.Usernames[1]))
+		for i, actor := range actors {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\n", i+1, actor.Name, actor.Counts[0], actor.Counts[1])
+		}
+		return tw.Flush()
+	}
+}
+
+func writeIndentedJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// markdownEscape keeps text from breaking out of a table cell or link
+func markdownEscape(text string) string {
+	return strings.NewReplacer(`|`, `\|`, `[`, `\[`, `]`, `\]`, "\n", " ").Replace(text)
+}
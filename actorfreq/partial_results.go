@@ -0,0 +1,87 @@
+package actorfreq
+
+import (
+	"time"
+)
+
+const leaderboardSize = 10
+
+var leaderboardInterval = 1 * time.Second
+
+type sseLeaderboardData struct {
+	Actors []sseLeaderboardEntry `json:"actors"`
+}
+
+type sseLeaderboardEntry struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// partialResults is the ProgressReporter for SSE streams. It streams films as they are resolved
+// along with a periodically recomputed leaderboard.
+type partialResults struct {
+	stream            *sseStream
+	roleFilters       []string
+	actors            map[string]*actorDetails
+	leaderboardSentAt time.Time
+}
+
+func newPartialResults(stream *sseStream, roleFilters []string) *partialResults {
+	return &partialResults{
+		stream:            stream,
+		roleFilters:       roleFilters,
+		actors:            make(map[string]*actorDetails),
+		leaderboardSentAt: time.Now(),
+	}
+}
+
+func (pr *partialResults) Report(p Progress) {
+	switch {
+	case p.Phase == PhaseResolving && p.Film == nil && p.Resolved == 0:
+		pr.stream.send(sseEventTotal, sseTotalData{Total: p.Total})
+	case p.Phase == PhaseResolving:
+		if p.Film != nil {
+			pr.addFilm(p.Film)
+		}
+		pr.stream.send(sseEventProgress, sseProgressData{
+			Progress:    p.Resolved,
+			CacheHits:   p.CacheHits,
+			CacheMisses: p.CacheMisses,
+			ETASeconds:  int(p.ETA.Round(time.Second).Seconds()),
+		})
+	case p.Phase == PhaseAggregating:
+		pr.sendLeaderboard()
+	}
+}
+
+// addFilm sends the film with its cast, warning when no cast could be found for it
+func (pr *partialResults) addFilm(film *ResolvedFilm) {
+	credits := Film{Slug: film.Slug, Title: film.Title}
+	for _, member := range film.Cast {
+		credits.Cast = append(credits.Cast, Credit{Actor: member.Actor, Roles: member.Roles})
+	}
+	addFilmCredits(pr.actors, credits, pr.roleFilters)
+
+	pr.stream.send(sseEventFilm, film)
+	if len(film.Cast) == 0 {
+		pr.stream.send(sseEventWarning, sseMessageData{Message: "No cast found", Slug: film.Slug})
+	}
+
+	if time.Since(pr.leaderboardSentAt) >= leaderboardInterval {
+		pr.sendLeaderboard()
+	}
+}
+
+// sendLeaderboard sends the top actors so far, ranked the same way as the final result
+func (pr *partialResults) sendLeaderboard() {
+	leaderboard := sseLeaderboardData{Actors: []sseLeaderboardEntry{}}
+	for i, actor := range cleanActors(pr.actors) {
+		if i >= leaderboardSize {
+			break
+		}
+		leaderboard.Actors = append(leaderboard.Actors, sseLeaderboardEntry{Name: actor.Name, Count: len(actor.Movies)})
+	}
+
+	pr.stream.send(sseEventLeaderboard, leaderboard)
+	pr.leaderboardSentAt = time.Now()
+}
@@ -0,0 +1,394 @@
+package actorfreq
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	precacheTaskCrawl   = "crawl" // expands into user tasks for the people the user follows
+	precacheTaskUser    = "user"  // expands into film tasks for the user's films
+	precacheTaskFilm    = "film"
+	precacheTaskRefresh = "refresh" // re-fetches a cached film whose cast may be out of date
+)
+
+const maxPrecacheAttempts = 3
+
+// precacheRetryBackoff is how long a failed task waits before its first retry, doubling after
+// each further failure
+var precacheRetryBackoff = time.Minute
+
+// PrecacheTask is a persisted unit of background precaching work
+type PrecacheTask struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"`
+	Value     string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"`
+	Seed      string `gorm:"uniqueIndex:idx_precache_task_kind_value_seed"` // the user whose request led to crawl tasks, so each seed crawls a user once
+	Depth     int    // hops from Seed for crawl tasks
+	Priority  int    `gorm:"index"` // reaching seeds for user tasks, appearances across users for film tasks
+	Attempts  int
+	NotBefore *time.Time `gorm:"index"` // when a failed task may be retried
+	CreatedAt time.Time
+}
+
+// precacher runs a pool of workers over the PrecacheTask queue in the analyzer's database,
+// pausing while requests are active
+type precacher struct {
+	*analyzer
+	mutex       sync.Mutex
+	cond        *sync.Cond
+	inFlight    map[uint]bool
+	started     bool
+	stopping    bool          // set on shutdown, so workers stop claiming tasks
+	stopped     chan struct{} // closed on shutdown
+	processed   int64
+	completions []time.Time // within the last minute, for throughput
+}
+
+func newPrecacher(a *analyzer) *precacher {
+	p := &precacher{analyzer: a, inFlight: make(map[uint]bool), stopped: make(chan struct{})}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+// startedPrecachers are woken once no requests are active. Requests to any server pause every
+// precacher, since they all share the Letterboxd rate limit.
+var (
+	startedPrecachersMutex sync.Mutex
+	startedPrecachers      []*precacher
+)
+
+// startRequest pauses precaching until the matching finishRequest
+func startRequest() {
+	atomic.AddInt32(&activeRequests, 1)
+}
+
+func finishRequest() {
+	if atomic.AddInt32(&activeRequests, -1) == 0 {
+		startedPrecachersMutex.Lock()
+		defer startedPrecachersMutex.Unlock()
+		for _, p := range startedPrecachers {
+			p.wake()
+		}
+	}
+}
+
+func (p *precacher) wake() {
+	p.mutex.Lock()
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+// start launches the workers once, resuming any tasks persisted before a restart
+func (p *precacher) start() {
+	if p.db == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.started || p.stopping {
+		return
+	}
+	p.started = true
+
+	startedPrecachersMutex.Lock()
+	startedPrecachers = append(startedPrecachers, p)
+	startedPrecachersMutex.Unlock()
+
+	numWorkers := p.config.Precache.Workers
+	p.logger.Info("Starting precache workers", "numWorkers", numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go p.work()
+	}
+}
+
+func (p *precacher) work() {
+	for {
+		task, ok := p.next()
+		if !ok {
+			return
+		}
+		p.process(task)
+	}
+}
+
+// next blocks until there are no active requests and a task is available, or returns false
+// once the precacher is stopping
+func (p *precacher) next() (PrecacheTask, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for {
+		if p.stopping {
+			return PrecacheTask{}, false
+		}
+		if atomic.LoadInt32(&activeRequests) == 0 {
+			if task, found := p.claimLocked(); found {
+				return task, true
+			}
+		}
+		p.cond.Wait()
+	}
+}
+
+// stop keeps workers from claiming more tasks and waits until ctx is done for the tasks in flight
+// to finish. Tasks are only deleted once processed, so unfinished ones are resumed on restart.
+func (p *precacher) stop(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.stopping {
+		p.stopping = true
+		close(p.stopped)
+	}
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+
+	startedPrecachersMutex.Lock()
+	startedPrecachers = slices.DeleteFunc(startedPrecachers, func(started *precacher) bool { return started == p })
+	startedPrecachersMutex.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mutex.Lock()
+		inFlight := len(p.inFlight)
+		p.mutex.Unlock()
+		if inFlight == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			p.logger.Warn("Stopped waiting for precache tasks", "inFlight", inFlight)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes the next task not already being processed, crawling and listing users before
+// fetching films so that films are fetched in order of how many crawled users have seen them,
+// and only refreshing stale films once nothing else is queued
+func (p *precacher) claim() (PrecacheTask, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.claimLocked()
+}
+
+func (p *precacher) claimLocked() (PrecacheTask, bool) {
+	query := p.db.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:  "CASE kind WHEN ? THEN 0 WHEN ? THEN 1 WHEN ? THEN 2 ELSE 3 END, priority DESC, id",
+		Vars: []any{precacheTaskCrawl, precacheTaskUser, precacheTaskFilm},
+	}}).Where("not_before IS NULL OR not_before <= ?", time.Now())
+	if len(p.inFlight) > 0 {
+		inFlightIDs := []uint{}
+		for id := range p.inFlight {
+			inFlightIDs = append(inFlightIDs, id)
+		}
+		query = query.Where("id NOT IN (?)", inFlightIDs)
+	}
+
+	var tasks []PrecacheTask
+	result := query.Limit(1).Find(&tasks)
+	if result.Error != nil || len(tasks) == 0 {
+		return PrecacheTask{}, false
+	}
+
+	p.inFlight[tasks[0].ID] = true
+	return tasks[0], true
+}
+
+// drain processes tasks in the calling goroutine until no task is ready, returning how many it
+// processed. Failed tasks waiting to be retried are left for later.
+func (p *precacher) drain() int {
+	numProcessed := 0
+	for {
+		task, found := p.claim()
+		if !found {
+			return numProcessed
+		}
+		p.process(task)
+		numProcessed++
+	}
+}
+
+func (p *precacher) process(task PrecacheTask) {
+	defer func() {
+		p.mutex.Lock()
+		delete(p.inFlight, task.ID)
+		p.mutex.Unlock()
+	}()
+
+	// Failing tasks are retried a few times, backing off between attempts, before they're dropped
+	if err := p.run(task); err != nil {
+		attempts := task.Attempts + 1
+		p.logger.Error("Precache task failed", "kind", task.Kind, "value", task.Value, "attempts", attempts, "error", err)
+		if attempts >= maxPrecacheAttempts {
+			p.db.Delete(&task)
+			return
+		}
+		backoff := precacheRetryBackoff << (attempts - 1)
+		p.db.Model(&task).Updates(map[string]any{"attempts": attempts, "not_before": time.Now().Add(backoff)})
+		time.AfterFunc(backoff, p.wake)
+		return
+	}
+	p.db.Delete(&task)
+	p.recordCompletion()
+}
+
+// run does a task's scraping
+func (p *precacher) run(task PrecacheTask) error {
+	switch task.Kind {
+	case precacheTaskCrawl:
+		return p.crawl(task)
+	case precacheTaskUser:
+		p.logger.Info("Fetching followedUser film slugs", "followedUser", task.Value)
+		filmSlugs, err := p.fetchFilmSlugs(context.Background(), task.Value, "release", nil)
+		if err != nil {
+			return err
+		}
+		p.enqueuePrecacheFilms(filmSlugs)
+	case precacheTaskFilm:
+		if _, exists := p.fetchCachedFilm(task.Value); !exists {
+			p.logger.Info("Precaching followedUser film slug", "slug", task.Value)
+			if _, err := p.fetchFilm(context.Background(), task.Value); err != nil {
+				return err
+			}
+		}
+	case precacheTaskRefresh:
+		p.logger.Info("Refreshing stale film", "slug", task.Value)
+		return p.refreshFilm(context.Background(), task.Value)
+	}
+	return nil
+}
+
+func (p *precacher) recordCompletion() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.processed++
+	p.completions = append(p.completions, time.Now())
+	p.pruneCompletionsLocked()
+}
+
+func (p *precacher) pruneCompletionsLocked() {
+	cutoff := time.Now().Add(-time.Minute)
+	i := 0
+	for i < len(p.completions) && p.completions[i].Before(cutoff) {
+		i++
+	}
+	p.completions = p.completions[i:]
+}
+
+func (p *precacher) enqueuePrecacheTasks(tasks []PrecacheTask, onConflict clause.OnConflict) {
+	if p.db == nil || len(tasks) == 0 {
+		return
+	}
+
+	onConflict.Columns = []clause.Column{{Name: "kind"}, {Name: "value"}, {Name: "seed"}}
+	err := p.db.Clauses(onConflict).CreateInBatches(&tasks, 500).Error
+	if err != nil {
+		p.logger.Error("Failed to enqueue precache tasks", "kind", tasks[0].Kind, "error", err)
+		return
+	}
+
+	p.wake()
+}
+
+func (p *precacher) enqueuePrecacheCrawls(seed string, depth int, usernames []string) {
+	tasks := []PrecacheTask{}
+	for _, username := range usernames {
+		tasks = append(tasks, PrecacheTask{Kind: precacheTaskCrawl, Value: username, Seed: seed, Depth: depth})
+	}
+	p.enqueuePrecacheTasks(tasks, clause.OnConflict{DoNothing: true})
+}
+
+// enqueuePrecacheUsers queues users for film listing, prioritized by how many of our own users reach them
+func (p *precacher) enqueuePrecacheUsers(usernames []string) {
+	counts := p.seedCounts(usernames)
+	tasks := []PrecacheTask{}
+	for _, username := range usernames {
+		tasks = append(tasks, PrecacheTask{Kind: precacheTaskUser, Value: username, Priority: counts[username]})
+	}
+	p.enqueuePrecacheTasks(tasks, clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"priority"})})
+}
+
+// enqueuePrecacheFilms queues the film slugs that aren't cached already, raising the priority of
+// films that are already queued so films seen by more crawled users are fetched first
+func (p *precacher) enqueuePrecacheFilms(filmSlugs []string) {
+	if p.db == nil {
+		return
+	}
+
+	cachedSlugs := []string{}
+	batchSize := p.config.FilmCache.LookupBatchSize
+	for i := 0; i < len(filmSlugs); i += batchSize {
+		end := min(i+batchSize, len(filmSlugs))
+		var batchCachedSlugs []string
+		p.db.Model(&Film{}).Where("slug IN (?)", filmSlugs[i:end]).Pluck("slug", &batchCachedSlugs)
+		cachedSlugs = append(cachedSlugs, batchCachedSlugs...)
+	}
+
+	tasks := []PrecacheTask{}
+	queued := make(map[string]bool)
+	for _, filmSlug := range difference(filmSlugs, cachedSlugs) {
+		if !queued[filmSlug] {
+			tasks = append(tasks, PrecacheTask{Kind: precacheTaskFilm, Value: filmSlug, Priority: 1})
+			queued[filmSlug] = true
+		}
+	}
+	p.enqueuePrecacheTasks(tasks, clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]any{"priority": gorm.Expr("precache_tasks.priority + 1")}),
+	})
+}
+
+// queueFollowingForPrecache queues a crawl of the people around username and starts the workers
+func (s *Server) queueFollowingForPrecache(username string) {
+	if s.db == nil || !s.features.PrecacheFollowing {
+		return
+	}
+
+	s.precache.enqueuePrecacheCrawls(username, 0, []string{username})
+	s.precache.start()
+}
+
+type precacheStatus struct {
+	QueuedCrawls        int64 `json:"queuedCrawls"`
+	QueuedFilms         int64 `json:"queuedFilms"`
+	QueuedUsers         int64 `json:"queuedUsers"`
+	QueuedRefreshes     int64 `json:"queuedRefreshes"`
+	InFlight            int   `json:"inFlight"`
+	Paused              bool  `json:"paused"`
+	Started             bool  `json:"started"`
+	Processed           int64 `json:"processed"`
+	ProcessedLastMinute int   `json:"processedLastMinute"`
+}
+
+func (p *precacher) status() precacheStatus {
+	var status precacheStatus
+	if p.db != nil {
+		p.db.Model(&PrecacheTask{}).Where("kind = ?", precacheTaskCrawl).Count(&status.QueuedCrawls)
+		p.db.Model(&PrecacheTask{}).Where("kind = ?", precacheTaskFilm).Count(&status.QueuedFilms)
+		p.db.Model(&PrecacheTask{}).Where("kind = ?", precacheTaskUser).Count(&status.QueuedUsers)
+		p.db.Model(&PrecacheTask{}).Where("kind = ?", precacheTaskRefresh).Count(&status.QueuedRefreshes)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pruneCompletionsLocked()
+	status.InFlight = len(p.inFlight)
+	status.Paused = atomic.LoadInt32(&activeRequests) != 0
+	status.Started = p.started
+	status.Processed = p.processed
+	status.ProcessedLastMinute = len(p.completions)
+
+	return status
+}
+
+func (s *Server) precacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.precache.status())
+}
@@ -0,0 +1,182 @@
+package actorfreq
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrecacheQueue(t *testing.T) {
+	actualHTTPCallCounts := make(map[string]int)
+	expectedHTTPCallCounts := map[string]int{
+		"https://letterboxd.com/seedA/following/":                1,
+		"https://letterboxd.com/seedA/following/page/2/":         1,
+		"https://letterboxd.com/seedA/followers/":                1,
+		"https://letterboxd.com/seedB/following/":                1,
+		"https://letterboxd.com/seedB/followers/":                1,
+		"https://letterboxd.com/popular/following/":              2, // once for each seed
+		"https://letterboxd.com/popular/followers/":              2,
+		"https://letterboxd.com/niche/following/":                1,
+		"https://letterboxd.com/niche/followers/":                1,
+		"https://letterboxd.com/deep/following/":                 0,
+		"https://letterboxd.com/popular/films/by/release/page/1": 1,
+		"https://letterboxd.com/popular/films/by/release/page/2": 1,
+		"https://letterboxd.com/niche/films/by/release/page/1":   1,
+		"https://letterboxd.com/niche/films/by/release/page/2":   1,
+		"https://letterboxd.com/deep/films/by/release/page/1":    1,
+		"https://letterboxd.com/deep/films/by/release/page/2":    1,
+		"https://letterboxd.com/film/film-a/":                    1,
+		"https://letterboxd.com/film/film-b/":                    1,
+		"https://letterboxd.com/film/film-c/":                    1,
+		"https://letterboxd.com/film/cached-film/":               0,
+	}
+	for key := range expectedHTTPCallCounts {
+		actualHTTPCallCounts[key] = 0
+	}
+
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "false")
+	t.Setenv("PRECACHE_CRAWL_DEPTH", "2")
+	t.Setenv("PRECACHE_CRAWL_FOLLOWERS", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		urlString := req.URL.String()
+		person := func(username string) string {
+			return `<table><tr><td class="table-person"><h3><a href="/` + username + `/">` + username + `</a></h3></td></tr></table>`
+		}
+		var responseString string
+		switch urlString {
+		case "https://letterboxd.com/seedA/following/":
+			responseString = person("popular") + `<a class="next" href="/seedA/following/page/2/">Older</a>`
+		case "https://letterboxd.com/seedA/following/page/2/":
+			responseString = person("niche")
+		case "https://letterboxd.com/seedB/following/":
+			responseString = person("popular")
+		case "https://letterboxd.com/popular/following/":
+			responseString = person("deep")
+		case "https://letterboxd.com/popular/films/by/release/page/1":
+			responseString = `<div data-film-slug="film-a" />` +
+				`<div data-film-slug="film-b" />` +
+				`<div data-film-slug="cached-film" />`
+		case "https://letterboxd.com/niche/films/by/release/page/1":
+			responseString = `<div data-film-slug="film-b" />`
+		case "https://letterboxd.com/deep/films/by/release/page/1":
+			responseString = `<div data-film-slug="film-c" />`
+		default:
+			responseString = ""
+		}
+		actualHTTPCallCounts[urlString]++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	cacheDB.Create(&Film{Slug: "cached-film", Title: "Cached Film"})
+
+	// Mark the workers as started so the queue is only processed by the test
+	server := NewServer()
+	p := server.precache
+	p.started = true
+
+	server.queueFollowingForPrecache("seedA")
+	server.queueFollowingForPrecache("seedB")
+	server.queueFollowingForPrecache("seedA") // duplicate crawls are only queued once
+
+	if status := p.status(); status.QueuedCrawls != 2 {
+		t.Errorf("Expected 2 queued crawls, got %+v", status)
+	}
+
+	task, found := p.claim()
+	if !found || task.Kind != precacheTaskCrawl || task.Value != "seedA" {
+		t.Fatalf("Expected crawl task for seedA, got %+v", task)
+	}
+	if next, found := p.claim(); !found || next.Value != "seedB" {
+		t.Errorf("Expected in-flight task to be skipped, got %+v", next)
+	} else {
+		p.process(next)
+	}
+	p.process(task)
+
+	var processed []string
+	for {
+		task, found := p.claim()
+		if !found {
+			break
+		}
+		p.process(task)
+		processed = append(processed, task.Kind+":"+task.Value)
+	}
+
+	// Each seed crawls the users it reaches, users reached by both seeds are listed first, and
+	// films seen by more users are fetched first
+	expectedProcessed := []string{
+		"crawl:popular", "crawl:popular", "crawl:niche",
+		"user:popular", "user:deep", "user:niche",
+		"film:film-b", "film:film-a", "film:film-c",
+	}
+	if !reflect.DeepEqual(expectedProcessed, processed) {
+		t.Errorf("Expected tasks to be processed in order %v, got %v", expectedProcessed, processed)
+	}
+
+	if _, exists := defaultAnalyzer().fetchCachedFilm("film-b"); !exists {
+		t.Errorf("Expected film-b to be precached")
+	}
+	if status := p.status(); status.QueuedCrawls+status.QueuedUsers+status.QueuedFilms != 0 || status.Processed != 11 {
+		t.Errorf("Expected an empty queue after 11 tasks, got %+v", status)
+	}
+
+	for key := range actualHTTPCallCounts {
+		if expectedHTTPCallCounts[key] != actualHTTPCallCounts[key] {
+			t.Errorf("Expected %d calls to %q, got %d", expectedHTTPCallCounts[key], key, actualHTTPCallCounts[key])
+		}
+	}
+}
+
+func TestPrecacheRetryBackoff(t *testing.T) {
+	initialBackoff := precacheRetryBackoff
+	defer func() { precacheRetryBackoff = initialBackoff }()
+	precacheRetryBackoff = 100 * time.Millisecond
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	numCalls := 0
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		numCalls++
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	p := NewServer().precache
+	p.started = true
+	p.enqueuePrecacheFilms([]string{"flaky"})
+
+	if numProcessed := p.drain(); numProcessed != 1 || numCalls != 1 {
+		t.Fatalf("Expected one failed attempt, got %d tasks and %d calls", numProcessed, numCalls)
+	}
+	if _, found := p.claim(); found {
+		t.Errorf("Expected the failed task to wait before it's retried")
+	}
+
+	time.Sleep(precacheRetryBackoff)
+	if numProcessed := p.drain(); numProcessed != 1 || numCalls != 2 {
+		t.Errorf("Expected the task to be retried after its backoff, got %d tasks and %d calls", numProcessed, numCalls)
+	}
+	var task PrecacheTask
+	if err := cacheDB.First(&task).Error; err != nil || task.Attempts != 2 {
+		t.Errorf("Expected the task to be kept for a third attempt, got %+v, %v", task, err)
+	}
+}
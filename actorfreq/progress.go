@@ -0,0 +1,174 @@
+package actorfreq
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressReporter is told how an analysis is getting on. Reports come one at a time, though not
+// always from the same goroutine, and hold up the analysis, so implementations should return
+// quickly.
+type ProgressReporter interface {
+	Report(Progress)
+}
+
+// ProgressFunc adapts a function to a ProgressReporter
+type ProgressFunc func(Progress)
+
+func (f ProgressFunc) Report(p Progress) {
+	f(p)
+}
+
+// Phase is a stage of an analysis
+type Phase string
+
+const (
+	PhaseListing     Phase = "listing"     // listing the user's films
+	PhaseResolving   Phase = "resolving"   // looking films up in the cache or fetching them from Letterboxd
+	PhaseAggregating Phase = "aggregating" // counting actors across the films
+)
+
+// Progress describes an analysis in flight. Fields may be added in minor versions.
+type Progress struct {
+	Phase       Phase
+	Pages       int           // pages of the user's films listed so far
+	Total       int           // films to resolve, known from PhaseResolving on
+	Resolved    int           // films resolved so far
+	CacheHits   int           // films resolved from the film cache
+	CacheMisses int           // films resolved by fetching them from Letterboxd
+	Film        *ResolvedFilm // the film just resolved, or nil, including when SQL aggregation resolves every film at once
+	Elapsed     time.Duration // since the analysis started
+	ETA         time.Duration // estimated time left resolving films, or 0 if unknown
+}
+
+// ResolvedFilm is a film resolved for an analysis, with its whole cast before role filters
+type ResolvedFilm struct {
+	Slug   string       `json:"slug"`
+	Title  string       `json:"title"`
+	Cached bool         `json:"cached"` // found in the film cache rather than fetched from Letterboxd
+	Cast   []CastMember `json:"cast"`
+}
+
+// CastMember is an actor credited in a film, with their roles joined by " / "
+type CastMember struct {
+	Actor string `json:"actor"`
+	Roles string `json:"roles"`
+}
+
+func newResolvedFilm(film Film, cached bool) *ResolvedFilm {
+	cast := []CastMember{}
+	for _, credit := range film.Cast {
+		cast = append(cast, CastMember{Actor: credit.Actor, Roles: credit.Roles})
+	}
+	return &ResolvedFilm{Slug: film.Slug, Title: film.Title, Cached: cached, Cast: cast}
+}
+
+// progressTracker reports an analysis's progress, if anyone is listening. A nil tracker reports
+// nothing.
+type progressTracker struct {
+	reporter   ProgressReporter
+	progress   Progress
+	mutex      sync.Mutex // film list pages are fetched in parallel
+	startedAt  time.Time
+	resolvedAt time.Time     // when the last film was resolved, or resolving started
+	fetchTime  time.Duration // spent fetching the cache misses
+}
+
+func newProgressTracker(reporter ProgressReporter) *progressTracker {
+	if reporter == nil || reporter == NoProgress {
+		return nil
+	}
+	return &progressTracker{reporter: reporter, startedAt: time.Now()}
+}
+
+func (pt *progressTracker) setPhase(phase Phase) {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.progress.Phase = phase
+	pt.progress.Film = nil
+	pt.report()
+}
+
+func (pt *progressTracker) listedPage() {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.progress.Pages++
+	pt.report()
+}
+
+func (pt *progressTracker) setTotal(total int) {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.progress.Phase = PhaseResolving
+	pt.progress.Total = total
+	pt.resolvedAt = time.Now()
+	pt.report()
+}
+
+func (pt *progressTracker) resolved(film Film, cached bool) {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.progress.Resolved++
+	if cached {
+		pt.progress.CacheHits++
+	} else {
+		pt.progress.CacheMisses++
+		pt.fetchTime += time.Since(pt.resolvedAt)
+	}
+	pt.resolvedAt = time.Now()
+
+	// Cache hits are nearly free, so estimate from the time taken by the misses, as if the
+	// remaining films all miss
+	pt.progress.ETA = 0
+	if pt.progress.CacheMisses > 0 {
+		remaining := pt.progress.Total - pt.progress.Resolved
+		pt.progress.ETA = pt.fetchTime / time.Duration(pt.progress.CacheMisses) * time.Duration(remaining)
+	}
+
+	pt.progress.Film = newResolvedFilm(film, cached)
+	pt.report()
+}
+
+// resolvedFromCache reports that n films were resolved from the cache at once, without loading
+// their casts
+func (pt *progressTracker) resolvedFromCache(n int) {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.progress.Resolved += n
+	pt.progress.CacheHits += n
+	pt.progress.ETA = 0
+	pt.progress.Film = nil
+	pt.report()
+}
+
+func (pt *progressTracker) report() {
+	pt.progress.Elapsed = time.Since(pt.startedAt)
+	pt.reporter.Report(pt.progress)
+}
+
+// NoProgress reports nothing, the same as a nil ProgressReporter
+var NoProgress ProgressReporter = noProgress{}
+
+type noProgress struct{}
+
+func (noProgress) Report(Progress) {}
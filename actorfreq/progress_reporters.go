@@ -0,0 +1,106 @@
+package actorfreq
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const terminalBarWidth = 30
+
+var terminalRedrawInterval = 100 * time.Millisecond
+
+// TerminalProgress draws a progress bar on w, which should be a terminal such as os.Stderr,
+// redrawing it in place at most every 100ms
+func TerminalProgress(w io.Writer) ProgressReporter {
+	return &terminalProgress{w: w}
+}
+
+type terminalProgress struct {
+	w         io.Writer
+	drawnAt   time.Time
+	lineWidth int
+}
+
+func (tp *terminalProgress) Report(p Progress) {
+	done := p.Phase == PhaseAggregating
+	if !done && time.Since(tp.drawnAt) < terminalRedrawInterval {
+		return
+	}
+
+	var line string
+	if p.Phase == PhaseListing {
+		line = fmt.Sprintf("Listing films... %d pages", p.Pages)
+	} else {
+		line = fmt.Sprintf("%s %d/%d films, %d cached", progressBar(p.Resolved, p.Total), p.Resolved, p.Total, p.CacheHits)
+		if p.ETA > 0 && !done {
+			line += fmt.Sprintf(", %s left", p.ETA.Round(time.Second))
+		}
+	}
+
+	// Pad over the rest of a longer previous line
+	fmt.Fprintf(tp.w, "\r%-*s", tp.lineWidth, line)
+	tp.lineWidth = len(line)
+	tp.drawnAt = time.Now()
+	if done {
+		fmt.Fprintln(tp.w)
+	}
+}
+
+func progressBar(done int, total int) string {
+	filled := terminalBarWidth
+	if total > 0 {
+		filled = terminalBarWidth * done / total
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(" ", terminalBarWidth-filled) + "]"
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// LogProgress logs each phase of an analysis to logger, and how many films it has resolved at
+// most every interval. Use logger.With to say which analysis it is.
+func LogProgress(logger *slog.Logger, interval time.Duration) ProgressReporter {
+	return &logProgress{logger: logger, interval: interval}
+}
+
+type logProgress struct {
+	logger   *slog.Logger
+	interval time.Duration
+	phase    Phase
+	loggedAt time.Time
+}
+
+func (lp *logProgress) Report(p Progress) {
+	if p.Phase != lp.phase {
+		lp.phase = p.Phase
+		lp.loggedAt = time.Now()
+		lp.logger.Info("Analysis phase",
+			"phase", p.Phase,
+			"pages", p.Pages,
+			"total", p.Total,
+			"cacheHits", p.CacheHits,
+			"cacheMisses", p.CacheMisses,
+			"elapsed", p.Elapsed.Round(time.Millisecond).String(),
+		)
+		return
+	}
+	if p.Film == nil || time.Since(lp.loggedAt) < lp.interval {
+		return
+	}
+
+	lp.loggedAt = time.Now()
+	lp.logger.Info("Analysis progress",
+		"resolved", p.Resolved,
+		"total", p.Total,
+		"cacheHits", p.CacheHits,
+		"cacheMisses", p.CacheMisses,
+		"eta", p.ETA.Round(time.Second).String(),
+	)
+}
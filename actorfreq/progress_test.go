@@ -0,0 +1,74 @@
+package actorfreq
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProgressReporters(t *testing.T) {
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/progressUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1><a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+
+	initialRedrawInterval := terminalRedrawInterval
+	defer func() { terminalRedrawInterval = initialRedrawInterval }()
+	terminalRedrawInterval = 0
+
+	var reports []Progress
+	var terminal, logs bytes.Buffer
+	reporters := []ProgressReporter{
+		ProgressFunc(func(p Progress) { reports = append(reports, p) }),
+		TerminalProgress(&terminal),
+		LogProgress(slog.New(slog.NewTextHandler(&logs, nil)), 0),
+		NoProgress,
+	}
+	rc := requestConfig{sortStrategy: "date"}
+	_, err := defaultAnalyzer().fetchActorsSequentially(context.Background(), "progressUser", rc, ProgressFunc(func(p Progress) {
+		for _, reporter := range reporters {
+			reporter.Report(p)
+		}
+	}))
+	if err != nil {
+		t.Fatalf("Failed to fetch actors: %v", err)
+	}
+
+	last := reports[len(reports)-1]
+	if last.Phase != PhaseAggregating || last.Pages != 1 || last.Resolved != 2 || last.CacheHits != 1 || last.CacheMisses != 1 {
+		t.Errorf("Expected 1 page and 2 films with 1 cache hit, got %+v", last)
+	}
+	if film := reports[len(reports)-2].Film; film == nil || film.Slug != "splash" || film.Cached {
+		t.Errorf("Expected splash to be fetched last, got %+v", film)
+	}
+
+	expectedLine := "[" + strings.Repeat("#", terminalBarWidth) + "] 2/2 films, 1 cached\n"
+	if !strings.HasSuffix(terminal.String(), expectedLine) {
+		t.Errorf("Expected the progress bar to end with %q, got %q", expectedLine, terminal.String())
+	}
+	for _, expected := range []string{"phase=listing", "phase=aggregating", "cacheHits=1 cacheMisses=1", `msg="Analysis progress" resolved=1`} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("Expected logs to contain %q, got %s", expected, logs.String())
+		}
+	}
+}
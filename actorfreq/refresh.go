@@ -0,0 +1,85 @@
+package actorfreq
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// filmTTL is how long a film's cast is trusted before it is refreshed. Films with an unknown
+// release year are treated as recent.
+func filmTTL(film Film, fcc FilmCacheConfig) time.Duration {
+	if len(film.Cast) == 0 {
+		return fcc.TTLEmpty
+	}
+	if film.ReleaseYear != 0 && film.ReleaseYear < time.Now().Year()-fcc.RecentYears {
+		return fcc.TTLOld
+	}
+	return fcc.TTLRecent
+}
+
+func setFilmExpiry(film *Film, fcc FilmCacheConfig) {
+	film.FetchedAt = time.Now()
+	expiresAt := film.FetchedAt.Add(filmTTL(*film, fcc))
+	film.ExpiresAt = &expiresAt
+}
+
+// refreshFilm re-scrapes a cached film and applies any changes to the cache
+func (a *analyzer) refreshFilm(ctx context.Context, slug string) error {
+	film, err := a.scrapeFilm(ctx, slug)
+	if err != nil {
+		return err
+	}
+	a.saveFilmToCache(film)
+	return nil
+}
+
+// enqueueStaleFilms queues refresh tasks for the films that expired longest ago
+func (p *precacher) enqueueStaleFilms(limit int) int {
+	if p.db == nil {
+		return 0
+	}
+
+	tasks := []PrecacheTask{}
+	for _, slug := range staleFilmSlugs(p.db, limit) {
+		tasks = append(tasks, PrecacheTask{Kind: precacheTaskRefresh, Value: slug})
+	}
+	p.enqueuePrecacheTasks(tasks, clause.OnConflict{DoNothing: true})
+
+	return len(tasks)
+}
+
+// staleFilmSlugs lists up to limit films that expired longest ago, after those that have never
+// had an expiry. SQLite and Postgres sort NULLs at opposite ends, so they're put first explicitly.
+func staleFilmSlugs(db *gorm.DB, limit int) []string {
+	var staleSlugs []string
+	db.Model(&Film{}).
+		Where("expires_at IS NULL OR expires_at < ?", time.Now()).
+		Order("expires_at IS NOT NULL, expires_at").
+		Limit(limit).
+		Pluck("slug", &staleSlugs)
+	return staleSlugs
+}
+
+// refreshStaleFilms periodically queues expired films for refreshing by the precache workers,
+// which only get to them once all other precaching is done, until the precache workers stop
+func (s *Server) refreshStaleFilms() {
+	if s.db == nil || !s.features.FilmRefresh {
+		return
+	}
+
+	fcc := s.config.FilmCache
+	s.precache.start()
+	for {
+		if numQueued := s.precache.enqueueStaleFilms(fcc.RefreshBatchSize); numQueued > 0 {
+			s.logger.Info("Queued stale films for refresh", "numQueued", numQueued)
+		}
+		select {
+		case <-s.precache.stopped:
+			return
+		case <-time.After(fcc.RefreshInterval):
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package actorfreq
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilmTTL(t *testing.T) {
+	fcc := FilmCacheConfig{TTLRecent: time.Hour, TTLOld: 2 * time.Hour, TTLEmpty: 3 * time.Hour, RecentYears: 2}
+	cast := []Credit{{Actor: "Tom Hanks", Roles: "Forrest Gump"}}
+
+	cases := []struct {
+		film        Film
+		expectedTTL time.Duration
+	}{
+		{Film{ReleaseYear: time.Now().Year(), Cast: cast}, time.Hour},
+		{Film{ReleaseYear: 0, Cast: cast}, time.Hour},
+		{Film{ReleaseYear: 1994, Cast: cast}, 2 * time.Hour},
+		{Film{ReleaseYear: 1994}, 3 * time.Hour},
+	}
+	for _, c := range cases {
+		if ttl := filmTTL(c.film, fcc); ttl != c.expectedTTL {
+			t.Errorf("Expected TTL %v for %+v, got %v", c.expectedTTL, c.film, ttl)
+		}
+	}
+}
+
+func TestRefreshStaleFilms(t *testing.T) {
+	actualHTTPCallCounts := make(map[string]int)
+	expectedHTTPCallCounts := map[string]int{
+		"https://letterboxd.com/film/toy-story/":  1,
+		"https://letterboxd.com/film/empty-film/": 1,
+		"https://letterboxd.com/film/fresh-film/": 0,
+	}
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		urlString := req.URL.String()
+		var responseString string
+		switch urlString {
+		case "https://letterboxd.com/film/toy-story/":
+			responseString = `<h1 class="filmtitle">Toy Story</h1>` +
+				`<div class="releaseyear"><a href="/films/year/1995/">1995</a></div>` +
+				`<a href="/actor/tom-hanks" title="Woody (voice)">Tom Hanks</a>` +
+				`<a href="/actor/don-rickles" title="Mr. Potato Head (voice)">Don Rickles</a>`
+		case "https://letterboxd.com/film/empty-film/":
+			responseString = `<h1 class="filmtitle">Empty Film</h1>` +
+				`<a href="/actor/tim-allen" title="Buzz Lightyear (voice)">Tim Allen</a>`
+		default:
+			responseString = ""
+		}
+		actualHTTPCallCounts[urlString]++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	expired := time.Now().Add(-time.Hour)
+	cacheDB.Create(&Film{
+		Slug:      "toy-story",
+		Title:     "Toy Story",
+		ExpiresAt: &expired,
+		Cast: []Credit{
+			{Actor: "Tom Hanks", Roles: "Woody"},
+			{Actor: "John Ratzenberger", Roles: "Hamm (voice)"},
+		},
+	})
+	cacheDB.Create(&Film{Slug: "empty-film", Title: "Empty Film"}) // cached before expiry was tracked
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "fresh-film", Title: "Fresh Film", Cast: []Credit{{Actor: "Tim Allen", Roles: "Buzz"}}})
+
+	cached, _ := defaultAnalyzer().fetchCachedFilm("toy-story")
+	var tomHanksID uint
+	for _, credit := range cached.Cast {
+		if credit.Actor == "Tom Hanks" {
+			tomHanksID = credit.ID
+		}
+	}
+
+	p := newPrecacher(defaultAnalyzer())
+	p.started = true
+
+	p.enqueuePrecacheFilms([]string{"queued-film"})
+	if numQueued := p.enqueueStaleFilms(10); numQueued != 2 {
+		t.Errorf("Expected 2 stale films to be queued, got %d", numQueued)
+	}
+
+	// Refreshes wait behind other precaching
+	if task, found := p.claim(); !found || task.Kind != precacheTaskFilm {
+		t.Errorf("Expected the film task to be claimed before refreshes, got %+v", task)
+	}
+	for {
+		task, found := p.claim()
+		if !found {
+			break
+		}
+		if task.Kind == precacheTaskRefresh {
+			p.process(task)
+		}
+	}
+
+	refreshed, _ := defaultAnalyzer().fetchCachedFilm("toy-story")
+	roles := make(map[string]string)
+	for _, credit := range refreshed.Cast {
+		roles[credit.Actor] = credit.Roles
+		if credit.Actor == "Tom Hanks" && credit.ID != tomHanksID {
+			t.Errorf("Expected Tom Hanks' credit to be updated in place, got ID %d instead of %d", credit.ID, tomHanksID)
+		}
+	}
+	if len(roles) != 2 || roles["Tom Hanks"] != "Woody (voice)" || roles["Don Rickles"] != "Mr. Potato Head (voice)" {
+		t.Errorf("Expected refreshed cast, got %+v", refreshed.Cast)
+	}
+	if refreshed.ReleaseYear != 1995 || refreshed.ExpiresAt == nil || !refreshed.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected refreshed release year and expiry, got %d %v", refreshed.ReleaseYear, refreshed.ExpiresAt)
+	}
+
+	var numCredits int64
+	cacheDB.Unscoped().Model(&Credit{}).Where("film_id = ?", refreshed.ID).Count(&numCredits)
+	if numCredits != 2 {
+		t.Errorf("Expected 2 credits without duplicates, got %d", numCredits)
+	}
+
+	if empty, _ := defaultAnalyzer().fetchCachedFilm("empty-film"); len(empty.Cast) != 1 {
+		t.Errorf("Expected empty film to get a cast, got %+v", empty.Cast)
+	}
+	if numQueued := p.enqueueStaleFilms(10); numQueued != 0 {
+		t.Errorf("Expected no stale films after refreshing, got %d", numQueued)
+	}
+
+	for key := range expectedHTTPCallCounts {
+		if expectedHTTPCallCounts[key] != actualHTTPCallCounts[key] {
+			t.Errorf("Expected %d calls to %q, got %d", expectedHTTPCallCounts[key], key, actualHTTPCallCounts[key])
+		}
+	}
+}
+
+func TestStaleFilmSlugsPutsUndatedFilmsFirst(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+
+	longExpired := time.Now().Add(-365 * 24 * time.Hour)
+	cacheDB.Create(&Film{Slug: "long-expired", Title: "Long Expired", ExpiresAt: &longExpired})
+	cacheDB.Create(&Film{Slug: "undated", Title: "Undated"})
+
+	if slugs := staleFilmSlugs(cacheDB, 1); len(slugs) != 1 || slugs[0] != "undated" {
+		t.Errorf("Expected the film without an expiry first, got %v", slugs)
+	}
+}
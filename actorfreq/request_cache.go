@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -17,18 +18,25 @@ type cacheItem struct {
 	element *list.Element
 }
 
+// Cache holds analysis results by request, evicting the least recently used once it is full
 type Cache struct {
 	items     map[string]*cacheItem
 	order     *list.List
 	mutex     sync.RWMutex
 	totalSize int
 	maxSize   int
+	hits      int64
+	misses    int64
+	evictions map[string]int64 // by reason
 }
 
-var requestCache = &Cache{
-	items:   make(map[string]*cacheItem),
-	order:   list.New(),
-	maxSize: 100 * 1024 * 1024, // 100MB
+// NewCache makes a request cache holding up to maxSize bytes of results, by their estimated size
+func NewCache(maxSize int) *Cache {
+	return &Cache{
+		items:   make(map[string]*cacheItem),
+		order:   list.New(),
+		maxSize: maxSize,
+	}
 }
 
 func calculateSize(actors []actorDetails) int {
@@ -64,6 +72,15 @@ func (c *Cache) remove(item *cacheItem) {
 	delete(c.items, item.key)
 }
 
+// evictLocked removes an item before it would otherwise be replaced, counting why
+func (c *Cache) evictLocked(item *cacheItem, reason string) {
+	c.remove(item)
+	if c.evictions == nil {
+		c.evictions = make(map[string]int64)
+	}
+	c.evictions[reason]++
+}
+
 func (c *Cache) set(key string, value []actorDetails, duration time.Duration) {
 	size := calculateSize(value)
 
@@ -81,7 +98,7 @@ func (c *Cache) set(key string, value []actorDetails, duration time.Duration) {
 			if oldest := c.order.Front(); oldest != nil {
 				key := oldest.Value.(string)
 				if item, found := c.items[key]; found {
-					c.remove(item)
+					c.evictLocked(item, "size")
 				}
 			}
 		}
@@ -96,13 +113,17 @@ func (c *Cache) set(key string, value []actorDetails, duration time.Duration) {
 	}
 }
 
+// get returns the value for key if it hasn't expired, making it the most recently used
 func (c *Cache) get(key string) ([]actorDetails, bool) {
-	c.mutex.RLock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	item, found := c.items[key]
-	c.mutex.RUnlock()
 	if !found || time.Now().UnixNano() > item.expiration {
+		atomic.AddInt64(&c.misses, 1)
 		return nil, false
 	}
+	c.order.MoveToBack(item.element)
+	atomic.AddInt64(&c.hits, 1)
 	return item.value, true
 }
 
@@ -110,7 +131,7 @@ func (c *Cache) evict() {
 	c.mutex.Lock()
 	for _, item := range c.items {
 		if time.Now().UnixNano() > item.expiration {
-			c.remove(item)
+			c.evictLocked(item, "expired")
 		}
 	}
 	c.mutex.Unlock()
@@ -119,7 +140,65 @@ func (c *Cache) evict() {
 func (c *Cache) evictAll() {
 	c.mutex.Lock()
 	for _, item := range c.items {
-		c.remove(item)
+		c.evictLocked(item, "cleared")
 	}
 	c.mutex.Unlock()
 }
+
+func (c *Cache) size() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.totalSize
+}
+
+func (c *Cache) evictionCounts() map[string]float64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	counts := map[string]float64{"cleared": 0, "expired": 0, "size": 0}
+	for reason, count := range c.evictions {
+		counts[reason] = float64(count)
+	}
+	return counts
+}
+
+type requestCacheStats struct {
+	NumItems  int                     `json:"numItems"`
+	TotalSize int                     `json:"totalSize"`
+	MaxSize   int                     `json:"maxSize"`
+	Hits      int64                   `json:"hits"`
+	Misses    int64                   `json:"misses"`
+	Items     []requestCacheItemStats `json:"items"`
+}
+
+type requestCacheItemStats struct {
+	Key       string    `json:"key"`
+	Size      int       `json:"size"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Expired   bool      `json:"expired"`
+}
+
+// stats lists items least recently used first, including expired items that haven't been evicted yet
+func (c *Cache) stats() requestCacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	stats := requestCacheStats{
+		NumItems:  len(c.items),
+		TotalSize: c.totalSize,
+		MaxSize:   c.maxSize,
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Items:     []requestCacheItemStats{},
+	}
+	now := time.Now().UnixNano()
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		item := c.items[element.Value.(string)]
+		stats.Items = append(stats.Items, requestCacheItemStats{
+			Key:       item.key,
+			Size:      item.size,
+			ExpiresAt: time.Unix(0, item.expiration),
+			Expired:   now > item.expiration,
+		})
+	}
+	return stats
+}
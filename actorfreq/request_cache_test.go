@@ -0,0 +1,26 @@
+package actorfreq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	actors := []actorDetails{{Name: "Tom Hanks"}}
+	size := calculateSize(actors)
+	cache := NewCache(2*size + 1)
+
+	cache.set("username=first", actors, time.Minute)
+	cache.set("username=second", actors, time.Minute)
+	cache.get("username=first")
+	cache.set("username=third", actors, time.Minute)
+
+	if _, found := cache.get("username=second"); found {
+		t.Error("Expected the least recently used item to be evicted")
+	}
+	for _, key := range []string{"username=first", "username=third"} {
+		if _, found := cache.get(key); !found {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+}
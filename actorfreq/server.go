@@ -1,34 +1,200 @@
 package actorfreq
 
 import (
+	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
-	"os"
-	"slices"
+	"net/url"
 	"strconv"
-	"sync"
-	"sync/atomic"
-	"time"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
-var FetchActorsPath string = "fetch-actors/"
+// FetchActorsPath is where the homepage streams analyses from, relative to the base path
+const FetchActorsPath = "fetch-actors/"
+
+// Server serves actorfreq with its own routes, request cache and precache workers, so it can be
+// mounted under a prefix in another app's router, or more than once in the same process
+type Server struct {
+	settings
+	mux            *http.ServeMux
+	precache       *precacher
+	analyses       context.Context // what the server's analyses run in, cancelled by Stop at its deadline
+	cancelAnalyses context.CancelFunc
+}
+
+// settings are what Options configure on a Server or Client
+type settings struct {
+	*analyzer
+	basePath     string
+	requestCache *Cache // nil to not cache results
+	features     Features
+}
+
+// Features switch optional behaviour on and off
+type Features struct {
+	SQLAggregation     bool `yaml:"sqlAggregation" env:"SQL_AGGREGATION"`                      // aggregate requests whose films are all cached in SQL, unless fetching sequentially
+	SequentialFetching bool `yaml:"sequentialFetching" env:"FETCH_ACTORS_SEQUENTIALLY"`        // look up and fetch films one at a time instead of in a batch
+	PrecacheFollowing  bool `yaml:"precacheFollowing" env:"DISABLE_PRECACHE_FOLLOWING,negate"` // crawl the people around each requested user in the background
+	FilmRefresh        bool `yaml:"filmRefresh" env:"DISABLE_FILM_REFRESH,negate"`             // queue stale films for refreshing in the background
+	Metrics            bool `yaml:"metrics"`                                                   // serve /metrics
+	Admin              bool `yaml:"admin"`                                                     // serve the admin endpoints, which also need credentials
+}
+
+// DefaultFeatures are the features enabled by default and by the environment, see Config
+func DefaultFeatures() Features {
+	return configFromEnv().Features
+}
+
+// Option configures a Server made by NewServer or a Client made by NewClient. Clients ignore the
+// options that only make sense for a server, such as WithBasePath.
+type Option func(*settings)
 
-func StartServer() {
-	AddHandlers("/")
+// WithDB keeps the film cache, jobs and precache queue in db, which must already be migrated,
+// instead of the database set up by SetUpDB. A nil db disables them.
+func WithDB(db *gorm.DB) Option {
+	return func(s *settings) { s.db = db }
+}
+
+// WithHTTPClient fetches pages from Letterboxd with client instead of http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *settings) { s.httpClient = client }
+}
+
+// WithRequestCache caches results in cache, which may be shared with other servers and clients,
+// instead of a 100MB cache of the server's own. Clients don't cache results without it.
+func WithRequestCache(cache *Cache) Option {
+	return func(s *settings) { s.requestCache = cache }
+}
 
-	port := "8080"
-	fmt.Println("Starting server on port", port)
-	http.ListenAndServe(":"+port, nil)
+// WithLogger logs requests, analyses and background work to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *settings) { s.logger = logger }
 }
 
+// WithBasePath serves the routes under basePath, e.g. "/actorfreq/", for routers that don't
+// strip the prefix they mount the server on
+func WithBasePath(basePath string) Option {
+	return func(s *settings) {
+		s.basePath = "/" + strings.Trim(basePath, "/") + "/"
+		if s.basePath == "//" {
+			s.basePath = "/"
+		}
+	}
+}
+
+// WithConfig configures the server with cfg instead of the environment. Its features and request
+// cache size are only defaults, so WithFeatures and WithRequestCache win whatever their order.
+func WithConfig(cfg *Config) Option {
+	return func(s *settings) { s.config = cfg }
+}
+
+// configOption finds the config given by WithConfig, if any, so that NewServer and NewClient can
+// make their defaults from it before applying the options
+func configOption(options []Option) *Config {
+	s := settings{analyzer: &analyzer{}}
+	for _, option := range options {
+		option(&s)
+	}
+	return s.config
+}
+
+// WithFeatures replaces DefaultFeatures()
+func WithFeatures(features Features) Option {
+	return func(s *settings) { s.features = features }
+}
+
+// NewServer makes a Server, by default using the database set up by SetUpDB and the features
+// enabled by the environment. Call Start to run its background work.
+func NewServer(options ...Option) *Server {
+	cfg := configOption(options)
+	if cfg == nil {
+		cfg = configFromEnv()
+	}
+	s := &Server{settings: settings{
+		analyzer:     newAnalyzer(cfg),
+		basePath:     "/",
+		requestCache: NewCache(cfg.RequestCache.MaxSize),
+		features:     cfg.Features,
+	}}
+	for _, option := range options {
+		option(&s.settings)
+	}
+	s.sqlAggregation = s.features.SQLAggregation
+	s.precache = newPrecacher(s.analyzer)
+	s.analyses, s.cancelAnalyses = context.WithCancel(context.Background())
+
+	s.mux = http.NewServeMux()
+	s.addHandlers()
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start resumes interrupted jobs and starts precaching and refreshing stale films
+func (s *Server) Start() {
+	go s.resumeJobs()
+	s.precache.start()
+	go s.refreshStaleFilms()
+}
+
+// StartServer serves on LISTEN_ADDR until the server fails or is signalled to shut down
+func StartServer() error {
+	return startServer(configFromEnv())
+}
+
+func startServer(cfg *Config) error {
+	s := NewServer(WithConfig(cfg))
+	s.Start()
+
+	err := serveUntilSignalled(newHTTPServer(cfg.ListenAddr, s), s)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// AddHandlers mounts a Server under root on http.DefaultServeMux and starts its background work.
+// Use NewServer instead to be able to Stop it.
 func AddHandlers(root string) {
-	http.HandleFunc(root, homeHandler)
-	http.HandleFunc(fmt.Sprintf("%s%s", root, FetchActorsPath), fetchActorsHandler)
-	http.HandleFunc(fmt.Sprintf("%s%s", root, "clear-request-cache"), clearRequestCacheHandler)
+	s := NewServer(WithBasePath(root))
+	s.Start()
+	http.Handle(root, s)
+}
+
+func (s *Server) addHandlers() {
+	root := s.basePath
+	handle := func(pattern string, handler http.HandlerFunc) {
+		s.mux.Handle(pattern, instrumentHandler(pattern, handler))
+	}
+	handle(root, homeHandler)
+	handle(fmt.Sprintf("GET %shealthz", root), healthzHandler)
+	handle(fmt.Sprintf("GET %sreadyz", root), s.readyzHandler)
+	handle(fmt.Sprintf("%s%s", root, FetchActorsPath), s.fetchActorsHandler)
+	handle(fmt.Sprintf("GET %sapi/%s/users/{username}/actors", root, apiVersion), s.apiActorsHandler)
+	handle(fmt.Sprintf("GET %sapi/%s/users/{username}/export/{format}", root, apiVersion), s.exportHandler)
+	handle(fmt.Sprintf("GET %sapi/%s/users/{username}/card.png", root, apiVersion), s.cardHandler)
+	handle(fmt.Sprintf("GET %sopenapi.json", root), s.openAPIHandler)
+	handle(fmt.Sprintf("POST %sshares", root), s.createShareHandler)
+	handle(fmt.Sprintf("DELETE %sshares/{id}", root), s.deleteShareHandler)
+	handle(fmt.Sprintf("GET %ss/{id}", root), s.sharePageHandler)
+	handle(fmt.Sprintf("GET %ss/{id}/card.png", root), s.shareCardHandler)
+	handle(fmt.Sprintf("POST %sjobs", root), s.createJobHandler)
+	handle(fmt.Sprintf("GET %sjobs/{id}", root), s.jobStatusHandler)
+	handle(fmt.Sprintf("GET %sjobs/{id}/events", root), s.jobEventsHandler)
+	if s.features.Metrics {
+		handle(fmt.Sprintf("GET %smetrics", root), s.metricsHandler)
+	}
+	if s.features.Admin {
+		s.addAdminHandlers()
+	}
 }
 
 //go:embed templates
@@ -53,80 +219,105 @@ type requestConfig struct {
 	roleFilters  []string
 }
 
-var precacheFollowingStarted = atomic.Bool{}
-
 // fetchActorsHandler processes the form submission, fetches actor details, and returns JSON
-func fetchActorsHandler(w http.ResponseWriter, r *http.Request) {
-	atomic.AddInt32(&activeRequests, 1)
-	defer atomic.AddInt32(&activeRequests, -1)
+func (s *Server) fetchActorsHandler(w http.ResponseWriter, r *http.Request) {
+	startRequest()
+	defer finishRequest()
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Invalid request method")
 		return
 	}
 
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_form", "Failed to parse form")
 		return
 	}
 
 	username := r.Form.Get("username")
 	if username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "missing_username", "Username is required")
 		return
 	}
 
-	requestConfig := getRequestConfig(r)
+	if err := validateRequestForm(r.Form); err != nil {
+		writeFieldError(w, r, err)
+		return
+	}
 
-	// Set the headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	requestConfig := getRequestConfig(r.Form)
 
-	requestCache.evict() // clear out expired cache items
-	requestCacheKey := r.Form.Encode()
-	var actors []actorDetails
-	if value, found := requestCache.get(requestCacheKey); found {
-		slog.Info("Request cache hit",
-			"requestCacheKey", requestCacheKey,
-			"numItems", len(requestCache.items),
-			"totalSize", requestCache.totalSize,
-		)
-		actors = value
-	} else {
-		if os.Getenv("FETCH_ACTORS_SEQUENTIALLY") == "true" {
-			actors = fetchActorsSequentially(username, requestConfig, &w)
-		} else {
-			actors = fetchActors(username, requestConfig, &w)
+	if acceptsJSON(r) {
+		if s.serveActorsJSON(w, username, requestConfig, r.Form.Encode()) {
+			s.queueFollowingForPrecache(username)
 		}
-		requestCache.set(requestCacheKey, actors, 10*time.Minute)
+		return
 	}
 
-	sendMapAsSSEData(w, map[string][]actorDetails{
-		"actors": actors,
-	})
+	stream := newSSEStream(w)
+	defer stream.close()
 
-	followedUsersToPrecacheForMutex.Lock()
-	for _, followedUser := range fetchFollowing(username) {
-		if !slices.Contains(followedUsersToPrecacheFor, followedUser) {
-			followedUsersToPrecacheFor = append(followedUsersToPrecacheFor, followedUser)
-		}
+	if s.streamActors(stream, username, requestConfig, r.Form.Encode()) {
+		s.queueFollowingForPrecache(username)
+	}
+}
+
+// streamActors sends the actors for username as a result event, reporting whether it succeeded
+func (s *Server) streamActors(stream *sseStream, username string, rc requestConfig, requestCacheKey string) bool {
+	actors, err := s.getActors(s.analyses, username, rc, requestCacheKey, newPartialResults(stream, rc.roleFilters))
+	if err != nil {
+		s.logger.Error("Failed to fetch actors", "username", username, "error", err)
+		stream.send(sseEventError, sseMessageData{
+			Message: fmt.Sprintf("Failed to fetch data from Letterboxd for %q", username),
+		})
+		return false
+	}
+	stream.send(sseEventResult, sseResultData{Actors: actors})
+	return true
+}
+
+// getActors serves actors from the request cache, fetching and caching them on a miss. Handlers
+// pass the server's analyses context rather than the request's, so analyses carry on after
+// clients go away, still filling the cache.
+func (s *settings) getActors(ctx context.Context, username string, rc requestConfig, requestCacheKey string, progress ProgressReporter) ([]actorDetails, error) {
+	if s.requestCache == nil {
+		return s.fetchActorsUsingFeatures(ctx, username, rc, progress)
+	}
+
+	s.requestCache.evict() // clear out expired cache items
+	if value, found := s.requestCache.get(requestCacheKey); found {
+		s.logger.Info("Request cache hit",
+			"requestCacheKey", requestCacheKey,
+			"numItems", len(s.requestCache.items),
+			"totalSize", s.requestCache.totalSize,
+		)
+		return value, nil
 	}
-	followedUsersToPrecacheForMutex.Unlock()
 
-	if !precacheFollowingStarted.Load() && os.Getenv("DISABLE_PRECACHE_FOLLOWING") != "true" {
-		go precacheFollowing()
+	actors, err := s.fetchActorsUsingFeatures(ctx, username, rc, progress)
+	if err != nil {
+		return nil, err
 	}
+	s.requestCache.set(requestCacheKey, actors, s.config.RequestCache.TTL)
+
+	return actors, nil
 }
 
-func getRequestConfig(r *http.Request) requestConfig {
-	sortStrategy := r.Form.Get("sortStrategy")
+func (s *settings) fetchActorsUsingFeatures(ctx context.Context, username string, rc requestConfig, progress ProgressReporter) ([]actorDetails, error) {
+	if s.features.SequentialFetching {
+		return s.fetchActorsSequentially(ctx, username, rc, progress)
+	}
+	return s.fetchActors(ctx, username, rc, progress)
+}
+
+func getRequestConfig(form url.Values) requestConfig {
+	sortStrategy := form.Get("sortStrategy")
 	if sortStrategy == "" {
 		sortStrategy = "date"
 	}
 
-	topNMoviesFormValue := r.Form.Get("topNMovies")
+	topNMoviesFormValue := form.Get("topNMovies")
 	topNMovies := -1
 	if topNMoviesFormValue != "" {
 		topNMoviesInt, err := strconv.Atoi(topNMoviesFormValue)
@@ -138,35 +329,18 @@ func getRequestConfig(r *http.Request) requestConfig {
 	return requestConfig{
 		sortStrategy: sortStrategy,
 		topNMovies:   topNMovies,
-		roleFilters:  r.Form["roleFilter"],
+		roleFilters:  form["roleFilter"],
 	}
 }
 
-var sseMutex sync.Mutex
-
-func sendMapAsSSEData[K comparable, V any](w http.ResponseWriter, m map[K]V) {
-	sseMutex.Lock()
-	defer sseMutex.Unlock()
-
-	md, err := json.Marshal(m)
-	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+func (s *Server) clearRequestCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if s.requestCache == nil {
+		w.Write([]byte("Request cache cleared successfully"))
 		return
 	}
-
-	// Send the JSON object as SSE data
-	fmt.Fprintf(w, "data: %s\n\n", string(md))
-
-	// Flush the response so the data is sent immediately
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
-	}
-}
-
-func clearRequestCacheHandler(w http.ResponseWriter, r *http.Request) {
-	slog.Warn("Clearing request cache", "numItems", len(requestCache.items), "totalSize", requestCache.totalSize)
-	requestCache.evictAll()
-	slog.Warn("Request cache cleared", "numItems", len(requestCache.items), "totalSize", requestCache.totalSize)
+	s.logger.Warn("Clearing request cache", "numItems", len(s.requestCache.items), "totalSize", s.requestCache.totalSize)
+	s.requestCache.evictAll()
+	s.logger.Warn("Request cache cleared", "numItems", len(s.requestCache.items), "totalSize", s.requestCache.totalSize)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Request cache cleared successfully"))
 }
@@ -0,0 +1,123 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServersMountedSideBySide(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "mount-token")
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	dbA := cacheDB
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	dbB := cacheDB
+
+	httpCalls := make(map[string]int)
+	client := &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		httpCalls[req.URL.String()]++
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/mountUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1><a href="/actor/tom-hanks" title="Allen Bauer">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	host := http.NewServeMux()
+	host.Handle("/a/", NewServer(WithDB(dbA), WithHTTPClient(client), WithBasePath("/a")))
+	host.Handle("/b/", NewServer(WithDB(dbB), WithHTTPClient(client), WithBasePath("b/"), WithFeatures(Features{})))
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer mount-token")
+		rec := httptest.NewRecorder()
+		host.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var response Result
+	rec := get("/a/api/v1/users/mountUser/actors")
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal %q: %v", rec.Body.String(), err)
+	}
+	if len(response.Actors) != 1 || response.Actors[0].Name != "Tom Hanks" {
+		t.Errorf("Expected Tom Hanks from the server's HTTP client, got %+v", response.Actors)
+	}
+	if httpCalls["https://letterboxd.com/film/big/"] != 1 {
+		t.Errorf("Expected the server's HTTP client to fetch each film once, got %v", httpCalls)
+	}
+
+	// Each server caches films in its own database
+	var statsA, statsB filmCacheStats
+	json.Unmarshal(get("/a/admin/cache-stats").Body.Bytes(), &statsA)
+	if statsA.Films != 2 {
+		t.Errorf("Expected the first server to have cached 2 films, got %+v", statsA)
+	}
+	if stats, _ := getFilmCacheStats(dbB, 1); stats.Films != 0 {
+		t.Errorf("Expected the second server's database to be untouched, got %+v", stats)
+	}
+	json.Unmarshal(get("/b/admin/cache-stats").Body.Bytes(), &statsB)
+	if statsB.Films != 0 {
+		t.Errorf("Expected admin endpoints to be disabled on the second server, got %+v", statsB)
+	}
+
+	// Disabled features are neither routed nor documented
+	var spec map[string]any
+	json.Unmarshal(get("/b/openapi.json").Body.Bytes(), &spec)
+	paths := spec["paths"].(map[string]any)
+	if _, found := paths["/metrics"]; found {
+		t.Error("Expected /metrics not to be documented when disabled")
+	}
+	if _, found := paths["/admin/cache-stats"]; found {
+		t.Error("Expected admin endpoints not to be documented when disabled")
+	}
+	servers := spec["servers"].([]any)
+	if url := servers[0].(map[string]any)["url"]; url != "/b" {
+		t.Errorf("Expected the base path as the server URL, got %v", url)
+	}
+	if code := get("/a/metrics").Code; code != http.StatusOK {
+		t.Errorf("Expected metrics on the first server, got %d", code)
+	}
+	if !strings.Contains(get("/b/metrics").Body.String(), "<form") {
+		t.Error("Expected /metrics to fall through to the homepage on the second server")
+	}
+}
+
+func TestWithConfigOrder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features = Features{Metrics: true}
+	cfg.RequestCache.MaxSize = 1234
+	requestCache := NewCache(1024 * 1024)
+	features := Features{Admin: true}
+
+	for name, options := range map[string][]Option{
+		"config first": {WithConfig(cfg), WithRequestCache(requestCache), WithFeatures(features)},
+		"config last":  {WithRequestCache(requestCache), WithFeatures(features), WithConfig(cfg)},
+	} {
+		server := NewServer(options...)
+		if server.config != cfg || server.requestCache != requestCache || server.features != features {
+			t.Errorf("%s: expected the explicit request cache and features to win over the config", name)
+		}
+	}
+
+	server := NewServer(WithConfig(cfg))
+	if server.requestCache.maxSize != 1234 || server.features != cfg.Features {
+		t.Errorf("Expected the config's request cache size and features, got %d and %+v", server.requestCache.maxSize, server.features)
+	}
+}
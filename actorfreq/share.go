@@ -0,0 +1,258 @@
+package actorfreq
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Share is a result saved under a short random ID so it can be linked to after the request cache
+// has forgotten it. Only the creator, who is given the delete token, can delete it early.
+type Share struct {
+	ID              string `gorm:"primaryKey"`
+	Username        string
+	Params          string // URL-encoded form values the result was analyzed with
+	Result          string // JSON-encoded Result
+	DeleteTokenHash string // hex SHA-256 of the delete token, which isn't stored
+	CreatedAt       time.Time
+	ExpiresAt       time.Time `gorm:"index"`
+}
+
+type shareResponse struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	DeleteToken string    `json:"deleteToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// newShareID makes an 8 character URL-safe ID from 48 random bits
+func newShareID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newDeleteToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashDeleteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareURL is the absolute URL of a share's page, for copying and for Open Graph tags. It's only
+// built from the request's Host header if there's no PublicURL, since clients control it.
+func (s *Server) shareURL(r *http.Request, id string) string {
+	if s.config.PublicURL != "" {
+		return fmt.Sprintf("%s%ss/%s", strings.TrimSuffix(s.config.PublicURL, "/"), s.basePath, id)
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%ss/%s", scheme, r.Host, s.basePath, id)
+}
+
+// fetchShare finds an unexpired share
+func (s *Server) fetchShare(id string) (Share, bool) {
+	var shares []Share
+	result := s.db.Where("id = ? AND expires_at > ?", id, time.Now()).Limit(1).Find(&shares)
+	if result.Error != nil || len(shares) == 0 {
+		return Share{}, false
+	}
+	return shares[0], true
+}
+
+// deleteExpiredShares is done whenever a share is created, so expired shares don't pile up
+func (s *Server) deleteExpiredShares() {
+	result := s.db.Where("expires_at <= ?", time.Now()).Delete(&Share{})
+	if result.Error != nil {
+		s.logger.Error("Failed to delete expired shares", "error", result.Error)
+	} else if result.RowsAffected > 0 {
+		s.logger.Info("Deleted expired shares", "numDeleted", result.RowsAffected)
+	}
+}
+
+// createShareHandler serves POST /shares, saving the result of the analysis described by the
+// same parameters as fetch-actors, which is usually still in the request cache. expiresInDays
+// shortens how long it's kept.
+func (s *Server) createShareHandler(w http.ResponseWriter, r *http.Request) {
+	startRequest()
+	defer finishRequest()
+
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Sharing requires a database")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_form", "Failed to parse form")
+		return
+	}
+
+	username := r.Form.Get("username")
+	if username == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_username", "Username is required")
+		return
+	}
+
+	// How long to keep the share isn't part of the analysis, so leave it out of the cache key
+	ttl := s.config.Share.TTL
+	if value := r.Form.Get("expiresInDays"); value != "" {
+		days, err := strconv.Atoi(value)
+		maxDays := int(s.config.Share.MaxTTL / (24 * time.Hour))
+		if err != nil || days < 1 || days > maxDays {
+			writeFieldError(w, r, &fieldError{Field: "expiresInDays", Message: fmt.Sprintf("must be a number from 1 to %d", maxDays)})
+			return
+		}
+		ttl = time.Duration(days) * 24 * time.Hour
+	}
+	r.Form.Del("expiresInDays")
+
+	if err := validateRequestForm(r.Form); err != nil {
+		writeFieldError(w, r, err)
+		return
+	}
+
+	actors, ok := s.getActorsOrWriteError(w, username, getRequestConfig(r.Form), r.Form.Encode())
+	if !ok {
+		return
+	}
+	result, err := json.Marshal(newResult(username, actors))
+	if err != nil {
+		s.logger.Error("Failed to encode share", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create share")
+		return
+	}
+
+	s.deleteExpiredShares()
+	deleteToken := newDeleteToken()
+	share := Share{
+		ID:              newShareID(),
+		Username:        username,
+		Params:          r.Form.Encode(),
+		Result:          string(result),
+		DeleteTokenHash: hashDeleteToken(deleteToken),
+		ExpiresAt:       time.Now().Add(ttl),
+	}
+	if err := s.db.Create(&share).Error; err != nil {
+		s.logger.Error("Failed to create share", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "database_error", "Failed to create share")
+		return
+	}
+	s.logger.Info("Created share", "shareID", share.ID, "username", username, "expiresAt", share.ExpiresAt)
+
+	writeJSON(w, http.StatusCreated, shareResponse{
+		ID:          share.ID,
+		URL:         s.shareURL(r, share.ID),
+		DeleteToken: deleteToken,
+		ExpiresAt:   share.ExpiresAt,
+	})
+}
+
+// deleteShareHandler serves DELETE /shares/{id} for whoever has the delete token, given as a
+// bearer token or the token parameter
+func (s *Server) deleteShareHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Sharing requires a database")
+		return
+	}
+
+	share, found := s.fetchShare(r.PathValue("id"))
+	if !found {
+		writeError(w, r, http.StatusNotFound, "share_not_found", "Share not found")
+		return
+	}
+
+	// Only from the header, since URLs end up in access logs and browser history
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(hashDeleteToken(token)), []byte(share.DeleteTokenHash)) != 1 {
+		writeError(w, r, http.StatusForbidden, "invalid_delete_token", "The delete token doesn't match this share")
+		return
+	}
+
+	if err := s.db.Delete(&share).Error; err != nil {
+		s.logger.Error("Failed to delete share", "shareID", share.ID, "error", err)
+		writeError(w, r, http.StatusInternalServerError, "database_error", "Failed to delete share")
+		return
+	}
+	s.logger.Info("Deleted share", "shareID", share.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadShare finds an unexpired share and decodes its result, writing an error if it can't
+func (s *Server) loadShare(w http.ResponseWriter, r *http.Request, id string) (Share, Result, bool) {
+	share, found := s.fetchShare(id)
+	if !found {
+		writeError(w, r, http.StatusNotFound, "share_not_found", "This share doesn't exist or has expired")
+		return share, Result{}, false
+	}
+	var result Result
+	if err := json.Unmarshal([]byte(share.Result), &result); err != nil {
+		s.logger.Error("Failed to decode share", "shareID", share.ID, "error", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return share, Result{}, false
+	}
+	return share, result, true
+}
+
+// sharePage is what share.html renders
+type sharePage struct {
+	URL         string
+	Description string
+	Username    string
+	Actors      []Actor
+	ExpiresAt   time.Time
+	HomePath    string
+}
+
+var shareTemplate = template.Must(template.New("share.html").Funcs(template.FuncMap{
+	"filmURL": letterboxdFilmURL,
+}).ParseFS(templates, "templates/share.html"))
+
+// sharePageHandler serves GET /s/{id} as a page that works without JavaScript, with Open Graph
+// tags so that links to it get a preview
+func (s *Server) sharePageHandler(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "database_unavailable", "Sharing requires a database")
+		return
+	}
+
+	share, result, ok := s.loadShare(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	var description []string
+	for _, actor := range result.Actors[:min(3, len(result.Actors))] {
+		description = append(description, fmt.Sprintf("%s (%d films)", actor.Name, actor.Count))
+	}
+	page := sharePage{
+		URL:         s.shareURL(r, share.ID),
+		Description: "Most frequent actors: " + strings.Join(description, ", "),
+		Username:    result.Username,
+		Actors:      result.Actors,
+		ExpiresAt:   share.ExpiresAt,
+		HomePath:    s.basePath,
+	}
+	if len(result.Actors) == 0 {
+		page.Description = "No actor appears in more than one film"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := shareTemplate.Execute(w, page); err != nil {
+		s.logger.Error("Failed to render share", "shareID", share.ID, "error", err)
+	}
+}
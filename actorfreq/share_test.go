@@ -0,0 +1,139 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShares(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/shareUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="big" /><div data-film-slug="splash" />`
+		case "https://letterboxd.com/film/big/":
+			responseString = `<h1 class="filmtitle">Big</h1><a href="/actor/tom-hanks" title="Josh">Tom Hanks</a>`
+		case "https://letterboxd.com/film/splash/":
+			responseString = `<h1 class="filmtitle">Splash</h1><a href="/actor/tom-hanks" title="Allen <Bauer>">Tom Hanks</a>`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	server := NewServer(WithBasePath("/actorfreq"))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodPost, "/actorfreq/shares?username=shareUser&expiresInDays=7", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var share shareResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &share); err != nil {
+		t.Fatalf("Failed to unmarshal share %q: %v", rec.Body.String(), err)
+	}
+	if len(share.ID) != 8 || share.URL != "http://example.com/actorfreq/s/"+share.ID || share.DeleteToken == "" {
+		t.Errorf("Expected an 8 character ID, its URL and a delete token, got %+v", share)
+	}
+	if expiresIn := time.Until(share.ExpiresAt); expiresIn < 6*24*time.Hour || expiresIn > 7*24*time.Hour {
+		t.Errorf("Expected the share to expire in 7 days, got %v", share.ExpiresAt)
+	}
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "/actorfreq/s/"+share.ID, nil))
+	page := rec.Body.String()
+	for _, expected := range []string{
+		`<meta property="og:title" content="shareUser's most frequent actors">`,
+		`<meta property="og:description" content="Most frequent actors: Tom Hanks (2 films)">`,
+		`<meta property="og:url" content="http://example.com/actorfreq/s/` + share.ID + `">`,
+		`<summary>Tom Hanks: 2 appearances</summary>`,
+		`<a href="https://letterboxd.com/film/splash/">Splash</a> - Allen &lt;Bauer&gt;`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the page to contain %q, got %s", expected, page)
+		}
+	}
+	if rec.Code != http.StatusOK || strings.Contains(page, "<script") {
+		t.Errorf("Expected a page without scripts, got %d", rec.Code)
+	}
+
+	deleteShare := func(token string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/actorfreq/shares/"+share.ID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(req).Code
+	}
+	if status := deleteShare("wrong"); status != http.StatusForbidden {
+		t.Errorf("Expected a wrong delete token to be refused, got %d", status)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodDelete, "/actorfreq/shares/"+share.ID+"?token="+share.DeleteToken, nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a delete token in the query to be refused, got %d", rec.Code)
+	}
+	if status := deleteShare(share.DeleteToken); status != http.StatusNoContent {
+		t.Errorf("Expected the share to be deleted, got %d", status)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/actorfreq/s/"+share.ID, nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted share to be gone, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/actorfreq/s/"+share.ID+"/card.png", nil)
+	req.Header.Set("Accept", "application/json")
+	var apiErr apiErrorResponse
+	if rec := serve(req); json.Unmarshal(rec.Body.Bytes(), &apiErr) != nil || apiErr.Error.Code != "share_not_found" {
+		t.Errorf("Expected a JSON share_not_found error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Expired shares aren't shown, and are cleaned up when the next share is created
+	cacheDB.Create(&Share{ID: "expired1", Username: "shareUser", Result: "{}", ExpiresAt: time.Now().Add(-time.Minute)})
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/actorfreq/s/expired1", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an expired share to be gone, got %d", rec.Code)
+	}
+	serve(httptest.NewRequest(http.MethodPost, "/actorfreq/shares?username=shareUser", nil))
+	var numExpired int64
+	cacheDB.Model(&Share{}).Where("id = ?", "expired1").Count(&numExpired)
+	if numExpired != 0 {
+		t.Errorf("Expected the expired share to be deleted")
+	}
+
+	for _, target := range []string{
+		"/actorfreq/shares?username=shareUser&expiresInDays=0",
+		"/actorfreq/shares?username=shareUser&expiresInDays=366",
+		"/actorfreq/shares?username=shareUser&sortStrategy=bogus",
+		"/actorfreq/shares",
+	} {
+		if rec := serve(httptest.NewRequest(http.MethodPost, target, nil)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestShareURLPrefersPublicURL(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/actorfreq/shares", nil)
+	req.Host = "attacker.example"
+
+	server := NewServer(WithBasePath("/actorfreq"))
+	if url := server.shareURL(req, "abcd1234"); url != "http://attacker.example/actorfreq/s/abcd1234" {
+		t.Errorf("Expected the Host header without a public URL, got %s", url)
+	}
+
+	cfg := DefaultConfig()
+	cfg.PublicURL = "https://actorfreq.example/"
+	server = NewServer(WithConfig(cfg), WithBasePath("/actorfreq"))
+	if url := server.shareURL(req, "abcd1234"); url != "https://actorfreq.example/actorfreq/s/abcd1234" {
+		t.Errorf("Expected the public URL, got %s", url)
+	}
+}
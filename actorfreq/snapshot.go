@@ -0,0 +1,313 @@
+package actorfreq
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Snapshots are gzipped NDJSON: a header line, one line per film (with its cast) and social
+// edge, and an end line with the record counts and a SHA-256 of the record lines in between.
+// IDs aren't exported, so a snapshot can be imported into any backend alongside existing data.
+const (
+	snapshotFormat  = "actorfreq-cache-snapshot"
+	snapshotVersion = 1
+
+	snapshotLineHeader     = "header"
+	snapshotLineFilm       = "film"
+	snapshotLineSocialEdge = "socialEdge"
+	snapshotLineEnd        = "end"
+
+	snapshotProgressInterval = 500
+)
+
+type snapshotLine struct {
+	Type       string              `json:"type"`
+	Header     *snapshotHeader     `json:"header,omitempty"`
+	Film       *snapshotFilm       `json:"film,omitempty"`
+	SocialEdge *snapshotSocialEdge `json:"socialEdge,omitempty"`
+	End        *snapshotEnd        `json:"end,omitempty"`
+}
+
+type snapshotHeader struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	SchemaVersion int       `json:"schemaVersion"` // informational, the format is versioned separately
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type snapshotFilm struct {
+	Slug        string           `json:"slug"`
+	Title       string           `json:"title"`
+	ReleaseYear int              `json:"releaseYear,omitempty"`
+	FetchedAt   time.Time        `json:"fetchedAt"`
+	ExpiresAt   *time.Time       `json:"expiresAt,omitempty"`
+	Cast        []snapshotCredit `json:"cast"`
+}
+
+type snapshotCredit struct {
+	Actor string `json:"actor"`
+	Roles string `json:"roles"`
+}
+
+type snapshotSocialEdge struct {
+	Seed     string `json:"seed"`
+	Username string `json:"username"`
+}
+
+type snapshotEnd struct {
+	Films       int    `json:"films"`
+	Credits     int    `json:"credits"`
+	SocialEdges int    `json:"socialEdges"`
+	SHA256      string `json:"sha256"`
+}
+
+// snapshotWriter writes snapshot lines, hashing the record lines as it goes
+type snapshotWriter struct {
+	encoder *json.Encoder
+	records *json.Encoder
+	hash    hash.Hash
+	end     snapshotEnd
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	h := sha256.New()
+	return &snapshotWriter{
+		encoder: json.NewEncoder(w),
+		records: json.NewEncoder(io.MultiWriter(w, h)),
+		hash:    h,
+	}
+}
+
+// exportSnapshot writes the film cache and social edges to w, reporting progress to progress
+func exportSnapshot(w io.Writer, progress io.Writer) (snapshotEnd, error) {
+	if cacheDB == nil {
+		return snapshotEnd{}, errors.New("no database configured")
+	}
+
+	gz := gzip.NewWriter(w)
+	sw := newSnapshotWriter(gz)
+
+	schemaVersion, err := currentMigrationVersion(cacheDB)
+	if err != nil {
+		return snapshotEnd{}, err
+	}
+	header := snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, SchemaVersion: schemaVersion, CreatedAt: time.Now()}
+	if err := sw.encoder.Encode(snapshotLine{Type: snapshotLineHeader, Header: &header}); err != nil {
+		return snapshotEnd{}, err
+	}
+
+	var films []Film
+	result := cacheDB.Preload("Cast").Order("id").FindInBatches(&films, snapshotProgressInterval, func(tx *gorm.DB, batch int) error {
+		for _, film := range films {
+			line := snapshotFilm{
+				Slug:        film.Slug,
+				Title:       film.Title,
+				ReleaseYear: film.ReleaseYear,
+				FetchedAt:   film.FetchedAt,
+				ExpiresAt:   film.ExpiresAt,
+				Cast:        []snapshotCredit{},
+			}
+			for _, credit := range uniqueCredits(film.Cast) {
+				line.Cast = append(line.Cast, snapshotCredit{Actor: credit.Actor, Roles: credit.Roles})
+			}
+			if err := sw.records.Encode(snapshotLine{Type: snapshotLineFilm, Film: &line}); err != nil {
+				return err
+			}
+			sw.end.Films++
+			sw.end.Credits += len(line.Cast)
+		}
+		fmt.Fprintf(progress, "Exported %d films\n", sw.end.Films)
+		return nil
+	})
+	if result.Error != nil {
+		return snapshotEnd{}, result.Error
+	}
+
+	var edges []SocialEdge
+	result = cacheDB.Order("id").FindInBatches(&edges, snapshotProgressInterval, func(tx *gorm.DB, batch int) error {
+		for _, edge := range edges {
+			line := snapshotSocialEdge{Seed: edge.Seed, Username: edge.Username}
+			if err := sw.records.Encode(snapshotLine{Type: snapshotLineSocialEdge, SocialEdge: &line}); err != nil {
+				return err
+			}
+			sw.end.SocialEdges++
+		}
+		fmt.Fprintf(progress, "Exported %d social edges\n", sw.end.SocialEdges)
+		return nil
+	})
+	if result.Error != nil {
+		return snapshotEnd{}, result.Error
+	}
+
+	sw.end.SHA256 = hex.EncodeToString(sw.hash.Sum(nil))
+	if err := sw.encoder.Encode(snapshotLine{Type: snapshotLineEnd, End: &sw.end}); err != nil {
+		return snapshotEnd{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return snapshotEnd{}, err
+	}
+
+	fmt.Fprintf(progress, "Exported %d films, %d credits and %d social edges\n", sw.end.Films, sw.end.Credits, sw.end.SocialEdges)
+	return sw.end, nil
+}
+
+// importSnapshot upserts a snapshot from r in a single transaction, which is rolled back unless
+// the snapshot is complete and its counts and checksum match what was read
+func importSnapshot(r io.Reader, progress io.Writer) (snapshotEnd, error) {
+	if cacheDB == nil {
+		return snapshotEnd{}, errors.New("no database configured")
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return snapshotEnd{}, fmt.Errorf("not a gzipped snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // films with huge casts make long lines
+
+	var read snapshotEnd
+	err = cacheDB.Transaction(func(tx *gorm.DB) error {
+		h := sha256.New()
+		slugs := make(map[string]bool)
+		var end *snapshotEnd
+
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			var line snapshotLine
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if end != nil {
+				return fmt.Errorf("line %d: unexpected %q after the end of the snapshot", lineNumber, line.Type)
+			}
+			if lineNumber == 1 {
+				if err := checkSnapshotHeader(line); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch line.Type {
+			case snapshotLineFilm:
+				if err := importSnapshotFilm(tx, line.Film, slugs); err != nil {
+					return fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				read.Films++
+				read.Credits += len(line.Film.Cast)
+				if read.Films%snapshotProgressInterval == 0 {
+					fmt.Fprintf(progress, "Imported %d films\n", read.Films)
+				}
+			case snapshotLineSocialEdge:
+				if line.SocialEdge == nil || line.SocialEdge.Seed == "" || line.SocialEdge.Username == "" {
+					return fmt.Errorf("line %d: incomplete social edge", lineNumber)
+				}
+				edge := SocialEdge{Seed: line.SocialEdge.Seed, Username: line.SocialEdge.Username}
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&edge).Error; err != nil {
+					return fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				read.SocialEdges++
+			case snapshotLineEnd:
+				if line.End == nil {
+					return fmt.Errorf("line %d: incomplete end of snapshot", lineNumber)
+				}
+				end = line.End
+				continue
+			default:
+				return fmt.Errorf("line %d: unknown record type %q", lineNumber, line.Type)
+			}
+
+			h.Write(scanner.Bytes())
+			h.Write([]byte("\n"))
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		if end == nil {
+			return errors.New("snapshot is truncated, it has no end")
+		}
+		read.SHA256 = hex.EncodeToString(h.Sum(nil))
+		if read != *end {
+			return fmt.Errorf("snapshot is corrupt, read %+v but expected %+v", read, *end)
+		}
+		return nil
+	})
+	if err != nil {
+		return snapshotEnd{}, fmt.Errorf("import rolled back: %w", err)
+	}
+
+	fmt.Fprintf(progress, "Imported %d films, %d credits and %d social edges\n", read.Films, read.Credits, read.SocialEdges)
+	return read, nil
+}
+
+func checkSnapshotHeader(line snapshotLine) error {
+	if line.Type != snapshotLineHeader || line.Header == nil || line.Header.Format != snapshotFormat {
+		return errors.New("not an actorfreq cache snapshot")
+	}
+	if line.Header.Version > snapshotVersion {
+		return fmt.Errorf("snapshot version %d is newer than the supported version %d", line.Header.Version, snapshotVersion)
+	}
+	return nil
+}
+
+// importSnapshotFilm upserts a film unless the cached copy was fetched more recently
+func importSnapshotFilm(tx *gorm.DB, line *snapshotFilm, slugs map[string]bool) error {
+	if line == nil || line.Slug == "" {
+		return errors.New("film has no slug")
+	}
+	if slugs[line.Slug] {
+		return fmt.Errorf("film %q appears more than once", line.Slug)
+	}
+	slugs[line.Slug] = true
+
+	film := Film{
+		Slug:        line.Slug,
+		Title:       line.Title,
+		ReleaseYear: line.ReleaseYear,
+		FetchedAt:   line.FetchedAt,
+		ExpiresAt:   line.ExpiresAt,
+	}
+	for _, credit := range line.Cast {
+		if credit.Actor == "" {
+			return fmt.Errorf("film %q has a credit with no actor", line.Slug)
+		}
+		film.Cast = append(film.Cast, Credit{Actor: credit.Actor, Roles: credit.Roles})
+	}
+	// Snapshots exported before credits were deduplicated can credit an actor twice
+	film.Cast = uniqueCredits(film.Cast)
+
+	var cached []Film
+	if err := tx.Where("slug = ?", line.Slug).Limit(1).Find(&cached).Error; err != nil {
+		return err
+	}
+	if len(cached) > 0 && cached[0].FetchedAt.After(line.FetchedAt) {
+		return nil
+	}
+	_, err := saveFilm(tx, film)
+	return err
+}
+
+// uniqueCredits keeps the first credit for each actor, as saveFilm does with a cached cast
+func uniqueCredits(cast []Credit) []Credit {
+	seen := make(map[string]bool)
+	unique := []Credit{}
+	for _, credit := range cast {
+		if !seen[credit.Actor] {
+			seen[credit.Actor] = true
+			unique = append(unique, credit)
+		}
+	}
+	return unique
+}
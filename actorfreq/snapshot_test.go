@@ -0,0 +1,148 @@
+package actorfreq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", ReleaseYear: 1988, Cast: []Credit{
+		{Actor: "Tom Hanks", Roles: "Josh"},
+		{Actor: "Elizabeth Perkins", Roles: "Susan"},
+	}})
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "empty", Title: "Empty"})
+	cacheDB.Create(&SocialEdge{Seed: "seed", Username: "friend"})
+	exported, _ := defaultAnalyzer().fetchCachedFilm("big")
+
+	var snapshot, progress bytes.Buffer
+	end, err := exportSnapshot(&snapshot, &progress)
+	if err != nil {
+		t.Fatalf("Expected export to succeed, got %v", err)
+	}
+	if end.Films != 2 || end.Credits != 2 || end.SocialEdges != 1 {
+		t.Errorf("Expected 2 films, 2 credits and 1 social edge, got %+v", end)
+	}
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	saveFilm(cacheDB, Film{Slug: "big", Title: "Big", FetchedAt: exported.FetchedAt.Add(-time.Hour), Cast: []Credit{{Actor: "John Heard", Roles: "Paul"}}})
+
+	// Importing twice upserts rather than duplicating
+	for i := 0; i < 2; i++ {
+		if _, err := importSnapshot(bytes.NewReader(snapshot.Bytes()), &progress); err != nil {
+			t.Fatalf("Expected import to succeed, got %v", err)
+		}
+	}
+
+	imported, _ := defaultAnalyzer().fetchCachedFilm("big")
+	actors := []string{}
+	for _, credit := range imported.Cast {
+		actors = append(actors, credit.Actor+": "+credit.Roles)
+	}
+	if !reflect.DeepEqual(actors, []string{"Tom Hanks: Josh", "Elizabeth Perkins: Susan"}) || imported.ReleaseYear != 1988 {
+		t.Errorf("Expected imported film to replace the cached one, got %+v", imported)
+	}
+	if !imported.FetchedAt.Equal(exported.FetchedAt) || !imported.ExpiresAt.Equal(*exported.ExpiresAt) {
+		t.Errorf("Expected fetch times to be kept, got %v and %v", imported.FetchedAt, imported.ExpiresAt)
+	}
+
+	var numFilms, numCredits, numEdges int64
+	cacheDB.Model(&Film{}).Count(&numFilms)
+	cacheDB.Unscoped().Model(&Credit{}).Count(&numCredits)
+	cacheDB.Model(&SocialEdge{}).Count(&numEdges)
+	if numFilms != 2 || numCredits != 2 || numEdges != 1 {
+		t.Errorf("Expected 2 films, 2 credits and 1 social edge, got %d, %d and %d", numFilms, numCredits, numEdges)
+	}
+	if !strings.Contains(progress.String(), "Imported 2 films, 2 credits and 1 social edges") {
+		t.Errorf("Expected import progress, got %q", progress.String())
+	}
+}
+
+func TestSnapshotIntegrityChecks(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "big", Title: "Big", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+	defaultAnalyzer().saveFilmToCache(Film{Slug: "splash", Title: "Splash", Cast: []Credit{{Actor: "Tom Hanks", Roles: "Allen Bauer"}}})
+
+	var snapshot bytes.Buffer
+	exportSnapshot(&snapshot, io.Discard)
+	gz, _ := gzip.NewReader(&snapshot)
+	ndjson, _ := io.ReadAll(gz)
+	lines := strings.SplitAfter(string(ndjson), "\n")
+
+	compress := func(s string) io.Reader {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return &buf
+	}
+
+	cases := []struct {
+		name          string
+		snapshot      io.Reader
+		expectedError string
+	}{
+		{"truncated", compress(strings.Join(lines[:3], "")), "truncated"},
+		{"tampered", compress(strings.Replace(string(ndjson), "Allen Bauer", "Walter Fielding", 1)), "corrupt"},
+		{"duplicated", compress(lines[0] + lines[1] + lines[1] + lines[3]), "appears more than once"},
+		{"newer", compress(strings.Replace(string(ndjson), `"version":1`, `"version":2`, 1)), "newer than the supported version"},
+		{"not a snapshot", compress(`{"type":"film"}` + "\n"), "not an actorfreq cache snapshot"},
+		{"not gzipped", strings.NewReader(string(ndjson)), "not a gzipped snapshot"},
+	}
+	for _, c := range cases {
+		setUpInMemorySQLiteDB()
+		migrateDB()
+
+		_, err := importSnapshot(c.snapshot, io.Discard)
+		if err == nil || !strings.Contains(err.Error(), c.expectedError) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.expectedError, err)
+		}
+		var numFilms int64
+		cacheDB.Model(&Film{}).Count(&numFilms)
+		if numFilms != 0 {
+			t.Errorf("%s: expected the import to be rolled back, got %d films", c.name, numFilms)
+		}
+	}
+}
+
+func TestSnapshotImportKeepsNewerFilmsAndMergesDuplicateCredits(t *testing.T) {
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	fetchedAt := time.Now().Add(-time.Hour)
+	saveFilm(cacheDB, Film{Slug: "big", Title: "Big", FetchedAt: fetchedAt, Cast: []Credit{{Actor: "Tom Hanks", Roles: "Josh"}}})
+	saveFilm(cacheDB, Film{Slug: "splash", Title: "Splash", FetchedAt: fetchedAt, Cast: []Credit{
+		{Actor: "Tom Hanks", Roles: "Allen Bauer"},
+		{Actor: "Tom Hanks", Roles: "Allen"},
+	}})
+
+	var snapshot bytes.Buffer
+	end, err := exportSnapshot(&snapshot, io.Discard)
+	if err != nil {
+		t.Fatalf("Expected export to succeed, got %v", err)
+	}
+	if end.Credits != 2 {
+		t.Errorf("Expected duplicate credits to be exported once, got %d credits", end.Credits)
+	}
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	saveFilm(cacheDB, Film{Slug: "big", Title: "Big", FetchedAt: time.Now(), Cast: []Credit{{Actor: "John Heard", Roles: "Paul"}}})
+	if _, err := importSnapshot(&snapshot, io.Discard); err != nil {
+		t.Fatalf("Expected import to succeed, got %v", err)
+	}
+
+	if big, _ := defaultAnalyzer().fetchCachedFilm("big"); len(big.Cast) != 1 || big.Cast[0].Actor != "John Heard" {
+		t.Errorf("Expected the more recently fetched film to be kept, got %+v", big)
+	}
+	if splash, _ := defaultAnalyzer().fetchCachedFilm("splash"); len(splash.Cast) != 1 || splash.Cast[0].Roles != "Allen Bauer" {
+		t.Errorf("Expected one credit for Tom Hanks, got %+v", splash)
+	}
+}
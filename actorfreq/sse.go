@@ -0,0 +1,138 @@
+package actorfreq
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Named events sent on an analysis stream, always ending with sseEventDone
+const (
+	sseEventTotal       = "total"
+	sseEventProgress    = "progress"
+	sseEventFilm        = "film"
+	sseEventWarning     = "warning"
+	sseEventLeaderboard = "leaderboard"
+	sseEventResult      = "result"
+	sseEventError       = "error"
+	sseEventDone        = "done"
+)
+
+var (
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetry             = 3 * time.Second
+)
+
+type sseTotalData struct {
+	Total int `json:"total"`
+}
+
+type sseProgressData struct {
+	Progress    int `json:"progress"`
+	CacheHits   int `json:"cacheHits"`
+	CacheMisses int `json:"cacheMisses"`
+	ETASeconds  int `json:"etaSeconds"` // 0 until a film has been fetched from Letterboxd
+}
+
+type sseMessageData struct {
+	Message string `json:"message"`
+	Slug    string `json:"slug,omitempty"`
+}
+
+type sseResultData struct {
+	Actors []actorDetails `json:"actors"`
+}
+
+// sseStream writes named server-sent events with increasing IDs to a single response
+type sseStream struct {
+	w      http.ResponseWriter
+	mutex  sync.Mutex
+	lastID int64
+	closed bool
+	stop   chan struct{}
+}
+
+// newSSEStream sets the SSE headers, sends the retry hint and starts heartbeats until close is called
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	stream := &sseStream{w: w, stop: make(chan struct{})}
+	stream.write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds()))
+	go stream.heartbeat()
+
+	return stream
+}
+
+func (s *sseStream) send(event string, data any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendLocked(s.lastID+1, event, data)
+}
+
+// sendWithID sends an event with an ID of the caller's, such as the version of what it describes,
+// so clients reconnecting with Last-Event-ID can skip what they've seen
+func (s *sseStream) sendWithID(id int64, event string, data any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendLocked(id, event, data)
+}
+
+func (s *sseStream) sendLocked(id int64, event string, data any) {
+	md, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Failed to marshal SSE data", "event", event, "error", err)
+		return
+	}
+
+	s.lastID = id
+	s.writeLocked(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", s.lastID, event, md))
+}
+
+// heartbeat sends comments so proxies don't time out the connection during long fetches
+func (s *sseStream) heartbeat() {
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.write(": heartbeat\n\n")
+		}
+	}
+}
+
+// close sends the terminal done event and stops heartbeats
+func (s *sseStream) close() {
+	s.send(sseEventDone, struct{}{})
+
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	close(s.stop)
+}
+
+func (s *sseStream) write(message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writeLocked(message)
+}
+
+func (s *sseStream) writeLocked(message string) {
+	// The response can't be written to once the handler has returned
+	if s.closed {
+		return
+	}
+
+	fmt.Fprint(s.w, message)
+
+	// Flush the response so the data is sent immediately
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,118 @@
+package actorfreq
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type parsedSSEEvent struct {
+	id    int
+	event string
+	data  string
+}
+
+func parseSSEEvents(t *testing.T, body string) (string, []parsedSSEEvent) {
+	var retry string
+	var events []parsedSSEEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var event parsedSSEEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "retry":
+				retry = value
+			case "id":
+				id, err := strconv.Atoi(value)
+				if err != nil {
+					t.Fatalf("Invalid event ID %q", value)
+				}
+				event.id = id
+			case "event":
+				event.event = value
+			case "data":
+				event.data = value
+			}
+		}
+		if event.event != "" {
+			events = append(events, event)
+		}
+	}
+	return retry, events
+}
+
+func TestFetchActorsHandlerEvents(t *testing.T) {
+	t.Setenv("DISABLE_PRECACHE_FOLLOWING", "true")
+
+	initialTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = initialTransport }()
+	http.DefaultTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var responseString string
+		switch req.URL.String() {
+		case "https://letterboxd.com/sseUser/films/by/date/page/1":
+			responseString = `<div data-film-slug="the-post" />` +
+				`<div data-film-slug="sully" />`
+		case "https://letterboxd.com/film/sully/":
+			responseString = `<h1 class="filmtitle">Sully</h1>` +
+				`<a href="/actor/tom-hanks" title="Sully">Tom Hanks</a>`
+		default:
+			responseString = ""
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(responseString)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	setUpInMemorySQLiteDB()
+	migrateDB()
+	cacheDB.Create(&Film{
+		Slug:  "the-post",
+		Title: "The Post",
+		Cast:  []Credit{{Actor: "Tom Hanks", Roles: "Ben Bradlee"}},
+	})
+
+	rec := httptest.NewRecorder()
+	NewServer().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fetch-actors/?username=sseUser", nil))
+
+	retry, events := parseSSEEvents(t, rec.Body.String())
+	if retry != strconv.FormatInt(sseRetry.Milliseconds(), 10) {
+		t.Errorf("Expected retry hint %d, got %q", sseRetry.Milliseconds(), retry)
+	}
+
+	var actualEvents []string
+	for i, event := range events {
+		actualEvents = append(actualEvents, event.event)
+		if event.id != i+1 {
+			t.Errorf("Expected event %d to have ID %d, got %d", i, i+1, event.id)
+		}
+	}
+	expectedEvents := []string{"total", "film", "progress", "film", "progress", "leaderboard", "result", "done"}
+	if !reflect.DeepEqual(expectedEvents, actualEvents) {
+		t.Errorf("Expected events %v, got %v", expectedEvents, actualEvents)
+	}
+
+	expectedResult := `{"actors":[{"Name":"Tom Hanks","Movies":[` +
+		`{"FilmSlug":"the-post","Title":"The Post","Roles":"Ben Bradlee"},` +
+		`{"FilmSlug":"sully","Title":"Sully","Roles":"Sully"}]}]}`
+	if len(events) != len(expectedEvents) {
+		return
+	}
+
+	expectedFilm := `{"slug":"sully","title":"Sully","cached":false,"cast":[{"actor":"Tom Hanks","roles":"Sully"}]}`
+	if events[3].data != expectedFilm {
+		t.Errorf("Expected film %s, got %s", expectedFilm, events[3].data)
+	}
+	expectedLeaderboard := `{"actors":[{"name":"Tom Hanks","count":2}]}`
+	if events[5].data != expectedLeaderboard {
+		t.Errorf("Expected leaderboard %s, got %s", expectedLeaderboard, events[5].data)
+	}
+	if events[6].data != expectedResult {
+		t.Errorf("Expected result %s, got %s", expectedResult, events[6].data)
+	}
+}
@@ -1,32 +1,38 @@
 package actorfreq
 
 import (
-	"log/slog"
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-func fetchDoc(url string) *goquery.Document {
-	resp, err := http.Get(url)
+// fetchDoc fetches and parses url, returning the response status, or "error" if there was no
+// response, along with the document or what went wrong
+func (a *analyzer) fetchDoc(ctx context.Context, url string) (*goquery.Document, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		slog.Error("Error fetching URL", "error", err)
-		return nil
+		return nil, "error", fmt.Errorf("creating request for %s: %w", url, err)
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, "error", fmt.Errorf("fetching %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
+	status := strconv.Itoa(resp.StatusCode)
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("Error: non-OK HTTP status", "status", resp.Status)
-		return nil
+		return nil, status, fmt.Errorf("fetching %s: %s", url, resp.Status)
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
-		slog.Error("Error loading HTML document", "error", err)
-		return nil
+		return nil, status, fmt.Errorf("parsing %s: %w", url, err)
 	}
 
-	return doc
+	return doc, status, nil
 }
 
 func difference(a, b []string) []string {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 
@@ -14,13 +15,14 @@ func main() {
 		slog.Warn("No .env file found")
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	// Logs go to stderr so that commands' output can be piped
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
 	slog.SetDefault(logger)
 
-	actorfreq.SetUpDB()
-
-	// actorfreq.CLI()
-	actorfreq.StartServer()
+	if err := actorfreq.CLI(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "actorfreq:", err)
+		os.Exit(1)
+	}
 }